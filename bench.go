@@ -0,0 +1,91 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/tsuzu/kachtomize/pkg/bench"
+)
+
+func runBench(args []string) {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	iterations := fs.Int("n", 5, "Number of builds per target and mode")
+	modes := fs.String("modes", "disk,inmemfs", "Comma separated modes to benchmark: disk, inmemfs")
+	cpuFile := fs.String("cpuprofile", "", "Write a pprof CPU profile to the file")
+	memFile := fs.String("memprofile", "", "Write a pprof heap profile to the file")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s bench [flags] [load dirs for inmemfs...] < targets\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *iterations < 1 {
+		fmt.Fprintln(os.Stderr, "-n must be positive")
+		os.Exit(2)
+	}
+
+	var benchModes []bench.Mode
+	for _, m := range strings.Split(*modes, ",") {
+		benchModes = append(benchModes, bench.Mode(strings.TrimSpace(m)))
+	}
+
+	// inmemfsはロードしたディレクトリの外を読めないので、ターゲットから推測せずに指定してもらう
+	for _, m := range benchModes {
+		if m == bench.ModeInMemFS && fs.NArg() == 0 {
+			fmt.Fprintln(os.Stderr, "inmemfs mode requires the load dirs containing the targets and their bases as arguments; use -modes disk to benchmark without them")
+			os.Exit(2)
+		}
+	}
+
+	wd, err := os.Getwd()
+
+	if err != nil {
+		panic(err)
+	}
+
+	targets, err := readTargets(os.Stdin, wd)
+
+	if err != nil {
+		panic(err)
+	}
+
+	opts := bench.Options{
+		KustomizerInit: newKustomizer,
+		Targets:        targets,
+		LoadDirs:       fs.Args(),
+		LoadJobs:       runtime.GOMAXPROCS(0),
+		Iterations:     *iterations,
+		Modes:          benchModes,
+	}
+
+	stopProfiling, err := startProfiling(*cpuFile, *memFile)
+
+	if err != nil {
+		panic(err)
+	}
+
+	result, err := bench.Run(opts)
+	stopProfiling()
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TARGET\tMODE\tP50\tP95\tBYTES/OP\tALLOCS/OP\t")
+	for _, s := range result.Stats {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%d\t\n", relPath(wd, s.Target), s.Mode, s.P50.Round(time.Microsecond), s.P95.Round(time.Microsecond), s.AllocBytes, s.Allocs)
+	}
+	w.Flush()
+
+	fmt.Println()
+	for _, m := range opts.Modes {
+		fmt.Printf("%s: file system prepared in %s\n", m, result.LoadTimes[m])
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// fileModeFlag is a flag.Value of a permission in octal such as 0644.
+type fileModeFlag os.FileMode
+
+func (f *fileModeFlag) String() string {
+	return fmt.Sprintf("%#o", os.FileMode(*f))
+}
+
+func (f *fileModeFlag) Set(s string) error {
+	mode, err := strconv.ParseUint(s, 8, 32)
+
+	if err != nil || mode > 0777 {
+		return fmt.Errorf("invalid file mode %q", s)
+	}
+
+	*f = fileModeFlag(mode)
+
+	return nil
+}
+
+// stringsFlag is a flag.Value of a repeatable flag. String joins the values with commas
+// so that reports record the values actually given.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(s string) error {
+	*f = append(*f, s)
+
+	return nil
+}
@@ -2,99 +2,1312 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"errors"
 	"flag"
+	"fmt"
 	"io"
+	"io/fs"
+	"log"
 	"os"
 	"path/filepath"
 	"runtime"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/tsuzu/kachtomize/pkg/archive"
+	"github.com/tsuzu/kachtomize/pkg/budget"
+	"github.com/tsuzu/kachtomize/pkg/check"
+	"github.com/tsuzu/kachtomize/pkg/checksum"
+	"github.com/tsuzu/kachtomize/pkg/deprecation"
+	"github.com/tsuzu/kachtomize/pkg/events"
 	"github.com/tsuzu/kachtomize/pkg/fsloader"
 	"github.com/tsuzu/kachtomize/pkg/fsutil"
+	"github.com/tsuzu/kachtomize/pkg/history"
 	"github.com/tsuzu/kachtomize/pkg/krunner"
+	"github.com/tsuzu/kachtomize/pkg/oci"
+	"github.com/tsuzu/kachtomize/pkg/output"
+	"github.com/tsuzu/kachtomize/pkg/policy"
+	"github.com/tsuzu/kachtomize/pkg/progress"
+	"github.com/tsuzu/kachtomize/pkg/prune"
+	"github.com/tsuzu/kachtomize/pkg/report"
+	"github.com/tsuzu/kachtomize/pkg/schema"
+	"github.com/tsuzu/kachtomize/pkg/semdiff"
+	"github.com/tsuzu/kachtomize/pkg/shard"
+	"github.com/tsuzu/kachtomize/pkg/trace"
+	"sigs.k8s.io/kustomize/api/konfig"
 	"sigs.k8s.io/kustomize/api/krusty"
+	"sigs.k8s.io/kustomize/api/resmap"
 	"sigs.k8s.io/kustomize/api/types"
 	"sigs.k8s.io/kustomize/kyaml/filesys"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+const (
+	ociModeCombined = "combined"
+	ociModeTarget   = "target"
+
+	deprecationsWarn = "warn"
+	deprecationsFail = "fail"
 )
 
 var (
+	fileMode os.FileMode = 0644
+
 	outputFileName string
 	useInMemFS     bool
+	showDiff       bool
+	reportFile     string
+	junitFile      string
+	eventsDest     string
+	progressMode   string
+	traceFile      string
+	traceFormat    string
+	cpuProfile     string
+	memProfile     string
+	stateDir       string
+	schedule       string
+	shardSpec      string
+	shardMode      string
+	shardDurations string
+	buildJobs      int
+	loadJobs       int
+	memBudget      string
+	ordered        bool
+	orderedWindow  int
+	outDir         string
+	outTemplate    string
+	layoutName     string
+	archiveDest    string
+	archiveFormat  string
+	formatNames    string
+	ociDir         string
+	ociMode        string
+	ociTag         string
+	ociSource      string
+	ociRevision    string
+	checksumsFile  string
+	signKeyFile    string
+	validateSchema bool
+	schemaFiles    []string
+	validateCRDs   bool
+	crdPaths       []string
+	kubeVersion    string
+	deprecations   string
+	policyPaths    []string
+	maxArtifact    string
+	maxResource    string
+	maxResources   int
+	formats        []output.Format
+	pruneOutputs   bool
+	pruneDryRun    bool
 	loadDirs       []string
 )
 
 func init() {
-	flag.StringVar(&outputFileName, "o", "artifact.yaml", "Output filename")
+	flag.StringVar(&outputFileName, "o", "artifact.yaml", "Output filename (\"-\" to write all artifacts to stdout)")
 	flag.BoolVar(&useInMemFS, "inmemfs", false, "Load files on memory before kustomize build")
+	flag.BoolVar(&showDiff, "diff", false, "Print a resource-aware diff against the previous artifact")
+	flag.StringVar(&reportFile, "report", "", "Write a JSON run report to the file")
+	flag.StringVar(&junitFile, "junit", "", "Write a JUnit XML report to the file")
+	flag.StringVar(&eventsDest, "events", "", "Write NDJSON events to the file (\"-\" for stdout, \"fd:N\" for a file descriptor)")
+	flag.StringVar(&progressMode, "progress", "auto", "Progress display: auto, tty, plain or none")
+	flag.StringVar(&traceFile, "trace", "", "Write timing spans of the load and build phases to the file")
+	flag.StringVar(&traceFormat, "trace-format", trace.FormatChrome, "Trace file format: chrome or otlp")
+	flag.StringVar(&cpuProfile, "cpuprofile", "", "Write a pprof CPU profile to the file")
+	flag.StringVar(&memProfile, "memprofile", "", "Write a pprof heap profile to the file")
+	flag.StringVar(&stateDir, "state-dir", "", "Directory to persist build durations and written outputs across runs, e.g. .kachtomize (disabled by default)")
+	flag.StringVar(&schedule, "schedule", "longest-first", "Build order: longest-first (by recorded durations) or input")
+	flag.StringVar(&shardSpec, "shard", "", "Build only the i-th of n shards of the targets, e.g. 2/4")
+	flag.StringVar(&shardMode, "shard-mode", shard.ModeHash, "Sharding strategy: hash or balanced (by the durations in -shard-durations)")
+	flag.StringVar(&shardDurations, "shard-durations", "", "durations.json shared by all shard jobs, e.g. from -state-dir of a previous run, to balance shards by (required by -shard-mode balanced)")
+	flag.IntVar(&buildJobs, "j", runtime.GOMAXPROCS(0), "Number of concurrent builds")
+	flag.IntVar(&loadJobs, "load-jobs", runtime.GOMAXPROCS(0), "Number of concurrent directory loads with -inmemfs")
+	flag.StringVar(&memBudget, "mem-budget", "", "Soft memory limit such as 4GiB; new builds are throttled while the heap is close to it")
+	flag.BoolVar(&ordered, "ordered", false, "Deliver artifacts in the input order regardless of build completion order")
+	flag.IntVar(&orderedWindow, "ordered-window", 64, "Maximum number of targets built or buffered ahead of the oldest pending one with -ordered")
+	flag.StringVar(&outDir, "out-dir", "", "Write artifacts under the directory mirroring target paths instead of into the targets")
+	flag.Var((*fileModeFlag)(&fileMode), "file-mode", "Permission of artifact files in octal")
+	flag.BoolVar(&pruneOutputs, "prune", false, "Remove artifacts written by previous runs which no target produced in this run (the input must list all targets)")
+	flag.BoolVar(&pruneDryRun, "prune-dry-run", false, "List artifacts -prune would remove without removing them")
+	flag.StringVar(&outTemplate, "out-template", output.DefaultPathTemplate, "Artifact path template under -out-dir ({{.RelDir}}, {{.Name}}, {{.FileName}})")
+	flag.StringVar(&archiveDest, "archive", "", "Write all artifacts into a .tar.gz or .zip archive with a digest manifest instead of files (\"-\" for stdout)")
+	flag.StringVar(&archiveFormat, "archive-format", "", "Archive format: tar.gz or zip (default: by the -archive extension, tar.gz for stdout)")
+	flag.StringVar(&ociDir, "oci-layout", "", "Write artifacts into an OCI image layout directory instead of files")
+	flag.StringVar(&ociMode, "oci-mode", ociModeCombined, "OCI images: combined (one image of all targets) or target (one image per target tagged with its path)")
+	flag.StringVar(&ociTag, "oci-tag", "latest", "Tag of the image with -oci-mode combined")
+	flag.StringVar(&ociSource, "oci-source", "", "Source URL recorded as the org.opencontainers.image.source annotation")
+	flag.StringVar(&ociRevision, "oci-revision", "", "Source revision recorded as the org.opencontainers.image.revision annotation, e.g. $(git rev-parse HEAD)")
+	flag.StringVar(&checksumsFile, "checksums", "", "Write a SHA256SUMS style manifest of every output written in this run to the file")
+	flag.StringVar(&signKeyFile, "sign-key", "", "Sign the -checksums manifest with the ed25519 private key in PKCS #8 PEM into <manifest>.sig")
+	flag.BoolVar(&validateSchema, "validate-schema", false, "Validate rendered resources against the built-in Kubernetes OpenAPI schema")
+	flag.Var((*stringsFlag)(&schemaFiles), "schema", "OpenAPI v2 schema file in JSON or YAML added to the built-in schema for validation (repeatable, implies -validate-schema)")
+	flag.BoolVar(&validateCRDs, "validate-crds", false, "Validate custom resources against the openAPIV3Schema of CRDs rendered in the same target; CRDs of other targets are not used unless given with -crd")
+	flag.Var((*stringsFlag)(&crdPaths), "crd", "File, directory or kustomization of CRDs to validate custom resources of every target against, e.g. the target rendering the CRDs (repeatable, implies -validate-crds)")
+	flag.StringVar(&kubeVersion, "kube-version", "", "Report resources of APIs deprecated or removed in the Kubernetes version, e.g. 1.29")
+	flag.StringVar(&deprecations, "deprecations", deprecationsWarn, "Severity of deprecated or removed APIs with -kube-version: warn, or fail to fail the targets")
+	flag.Var((*stringsFlag)(&policyPaths), "policy", "Starlark policy file, or directory of .star files, whose rules run on the resources of every target (repeatable)")
+	flag.StringVar(&maxArtifact, "max-artifact-bytes", "", "Fail targets whose YAML artifact is larger than the size such as 10MiB")
+	flag.StringVar(&maxResource, "max-resource-bytes", strconv.Itoa(budget.DefaultResourceBytes), "Fail targets with a resource larger than the size (0 to disable)")
+	flag.IntVar(&maxResources, "max-resources", 0, "Fail targets with more resources than the number (0 to disable)")
+	flag.StringVar(&formatNames, "format", string(output.FormatYAML), "Comma-separated artifact formats: yaml, json (a v1 List) and jsonl (one resource per line)")
+	flag.StringVar(&layoutName, "layout", string(output.LayoutSingle), "Artifact layout: single, or one file per resource with resource, namespace or kind subdirectories")
+}
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		runBench(os.Args[2:])
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "verify" {
+		runVerify(os.Args[2:])
+		return
+	}
 
 	flag.Parse()
 
 	loadDirs = flag.Args()
+
+	if !runBuild() {
+		os.Exit(1)
+	}
 }
 
-func main() {
+func newKustomizer() *krusty.Kustomizer {
+	opt := krusty.MakeDefaultOptions()
+	c := types.EnabledPluginConfig(types.BploUseStaticallyLinked)
+	c.FnpLoadingOptions.EnableExec = true
+	opt.LoadRestrictions = types.LoadRestrictionsNone
+	opt.PluginConfig = c
+
+	return krusty.MakeKustomizer(opt)
+}
+
+// readTargets reads target directories from r line by line
+// and returns them as absolute paths.
+func readTargets(r io.Reader, wd string) ([]string, error) {
+	var targets []string
+
+	reader := bufio.NewReader(r)
+	for {
+		// ファイルパスがそんな長いわけないのでisPrefixは無視します
+		line, _, err := reader.ReadLine()
+
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+
+			return nil, err
+		}
+
+		if len(line) == 0 {
+			continue
+		}
+
+		targets = append(targets, filepath.Join(wd, string(line)))
+	}
+
+	return targets, nil
+}
+
+var byteUnits = []struct {
+	suffix string
+	size   uint64
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"TiB", 1 << 40},
+	{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"TB", 1e12},
+	{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30}, {"T", 1 << 40},
+	{"B", 1},
+}
+
+// parseBytes parses a size such as "512MiB", "4G" or "1000000".
+func parseBytes(size string) (uint64, error) {
+	s := strings.TrimSpace(size)
+	unit := uint64(1)
+
+	for _, u := range byteUnits {
+		if strings.HasSuffix(s, u.suffix) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, u.suffix))
+			unit = u.size
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(s, 64)
+
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", size)
+	}
+
+	return uint64(n * float64(unit)), nil
+}
+
+func newBudget() budget.Budget {
+	b := budget.Budget{Resources: maxResources}
+
+	for _, f := range []struct {
+		value string
+		dst   *int64
+	}{{maxArtifact, &b.ArtifactBytes}, {maxResource, &b.ResourceBytes}} {
+		if f.value == "" {
+			continue
+		}
+
+		n, err := parseBytes(f.value)
+
+		if err != nil {
+			panic(err)
+		}
+		*f.dst = int64(n)
+	}
+
+	return b
+}
+
+func hasFormat(formats []output.Format, format output.Format) bool {
+	for _, f := range formats {
+		if f == format {
+			return true
+		}
+	}
+
+	return false
+}
+
+func relPath(wd, path string) string {
+	rel, err := filepath.Rel(wd, path)
+
+	if err != nil {
+		return path
+	}
+
+	return rel
+}
+
+func relPaths(wd string, paths []string) []string {
+	rels := make([]string, len(paths))
+	for i, p := range paths {
+		rels[i] = relPath(wd, p)
+	}
+
+	return rels
+}
+
+func absPaths(wd string, rels []string) []string {
+	paths := make([]string, len(rels))
+	for i, rel := range rels {
+		paths[i] = filepath.Join(wd, rel)
+	}
+
+	return paths
+}
+
+func runBuild() bool {
+	startedAt := time.Now()
+
+	stopProfiling, err := startProfiling(cpuProfile, memProfile)
+
+	if err != nil {
+		panic(err)
+	}
+	defer stopProfiling()
+
+	wd, err := os.Getwd()
+
+	if err != nil {
+		panic(err)
+	}
+
+	display := newProgressDisplay(wd)
+
+	// ビルドが終わってからトレースを書けずに失敗しないように、先に確認しておく
+	if err := trace.ValidateFormat(traceFormat); err != nil {
+		panic(err)
+	}
+
+	var tracer *trace.Tracer
+	if traceFile != "" {
+		tracer = trace.New()
+	}
+
+	if users := stdoutUsers(); len(users) > 1 {
+		panic(strings.Join(users, ", ") + " cannot be used together as they write to stdout")
+	}
+
+	layout, err := output.ParseLayout(layoutName)
+
+	if err != nil {
+		panic(err)
+	}
+
+	formats, err = output.ParseFormats(formatNames)
+
+	if err != nil {
+		panic(err)
+	}
+
+	if outputFileName == "-" && len(formats) != 1 {
+		panic("-o - can write only one -format")
+	}
+
+	if showDiff && !hasFormat(formats, output.FormatYAML) {
+		panic("-diff requires yaml in -format")
+	}
+
+	if layout != output.LayoutSingle && outputFileName == "-" {
+		panic("-layout " + layoutName + " cannot be used with -o -")
+	}
+
+	if archiveDest != "" && (outputFileName == "-" || outDir != "" || showDiff || pruneOutputs || pruneDryRun) {
+		panic("-archive cannot be used with -o -, -out-dir, -diff or -prune")
+	}
+
+	if ociDir != "" && (archiveDest != "" || outputFileName == "-" || outDir != "" || showDiff || pruneOutputs || pruneDryRun) {
+		panic("-oci-layout cannot be used with -archive, -o -, -out-dir, -diff or -prune")
+	}
+
+	if checksumsFile != "" && (outputFileName == "-" || archiveDest == "-") {
+		panic("-checksums cannot be used with -o - or -archive -")
+	}
+
+	if signKeyFile != "" && checksumsFile == "" {
+		panic("-sign-key requires -checksums")
+	}
+
+	if ociMode != ociModeTarget && ociMode != ociModeCombined {
+		panic(fmt.Sprintf("unknown OCI mode: %s", ociMode))
+	}
+
+	if deprecations != deprecationsWarn && deprecations != deprecationsFail {
+		panic(fmt.Sprintf("unknown deprecations mode: %s", deprecations))
+	}
+
+	if (pruneOutputs || pruneDryRun) && (stateDir == "" || shardSpec != "" || outputFileName == "-") {
+		panic("-prune requires -state-dir and cannot be used with -shard or -o -")
+	}
+
+	if shardMode != shard.ModeHash && shardMode != shard.ModeBalanced {
+		panic(fmt.Sprintf("unknown shard mode: %s", shardMode))
+	}
+
+	// 各ジョブが別々の履歴を読むと、どのshardにも入らないターゲットができる
+	if shardMode == shard.ModeBalanced && shardDurations == "" {
+		panic("-shard-mode balanced requires -shard-durations shared by all shards")
+	}
+
+	if buildJobs < 1 || loadJobs < 1 {
+		panic("-j and -load-jobs must be positive")
+	}
+
+	var memLimit uint64
+	if memBudget != "" {
+		memLimit, err = parseBytes(memBudget)
+
+		if err != nil {
+			panic(err)
+		}
+
+		debug.SetMemoryLimit(int64(memLimit))
+	}
+
 	fs := filesys.MakeFsOnDisk()
 
 	if useInMemFS {
 		fs = fsutil.MakeFsInMemory()
 		loader := fsloader.New(fs)
+		loader.SetTracer(tracer)
+
+		if display != nil {
+			display.StartLoading()
+			loader.RegisterCallback(display.FileLoaded)
+		}
 
-		if err := loader.LoadAll(loadDirs, runtime.GOMAXPROCS(0)); err != nil {
+		span := tracer.Start("fsloader.LoadAll", 0)
+		if err := loader.LoadAll(loadDirs, loadJobs); err != nil {
 			panic(err)
 		}
+		span.Finish()
 
 		fs = fsutil.NewReadOnlyFS(fs)
 	}
 
-	kustomizerInit := func() *krusty.Kustomizer {
-		opt := krusty.MakeDefaultOptions()
-		c := types.EnabledPluginConfig(types.BploUseStaticallyLinked)
-		c.FnpLoadingOptions.EnableExec = true
-		opt.LoadRestrictions = types.LoadRestrictionsNone
-		opt.PluginConfig = c
+	runner := krunner.New(newKustomizer, fs, buildJobs)
+	runner.SetTracer(tracer)
 
-		return krusty.MakeKustomizer(opt)
+	if memLimit != 0 {
+		runner.SetMemoryBudget(memLimit)
 	}
 
-	krunner := krunner.New(kustomizerInit, fs, runtime.GOMAXPROCS(0))
-	krunner.RegisterCallback(func(dir string, data []byte) {
-		fileName := filepath.Join(dir, outputFileName)
+	if ordered {
+		runner.SetOrdered(orderedWindow)
+	}
 
-		if err := os.MkdirAll(filepath.Dir(fileName), 0777); err != nil {
+	if validateSchema || len(schemaFiles) != 0 {
+		v, err := schema.NewOpenAPIValidator(true, schemaFiles)
+
+		if err != nil {
 			panic(err)
 		}
 
-		if err := os.WriteFile(fileName, data, 0777); err != nil {
+		runner.RegisterChecker(v)
+	}
+
+	if validateCRDs || len(crdPaths) != 0 {
+		v, err := newCRDValidator(crdPaths)
+
+		if err != nil {
 			panic(err)
 		}
-	})
 
-	wd, err := os.Getwd()
+		runner.RegisterChecker(v)
+	}
 
-	if err != nil {
-		panic(err)
+	if kubeVersion != "" {
+		v, err := deprecation.ParseVersion(kubeVersion)
+
+		if err != nil {
+			panic(err)
+		}
+
+		severity := check.SeverityWarning
+		if deprecations == deprecationsFail {
+			severity = check.SeverityError
+		}
+
+		runner.RegisterChecker(deprecation.NewChecker(v, severity))
 	}
 
-	reader := bufio.NewReader(os.Stdin)
-	for {
-		// ファイルパスがそんな長いわけないのでisPrefixは無視します
-		line, _, err := reader.ReadLine()
+	if len(policyPaths) != 0 {
+		c := policy.NewChecker()
+
+		for _, p := range policyPaths {
+			if err := c.AddPath(p); err != nil {
+				panic(err)
+			}
+		}
+
+		log.Printf("loaded %d policy rules", c.Len())
+		runner.RegisterChecker(c)
+	}
+
+	if b := newBudget(); !b.IsZero() {
+		runner.RegisterChecker(budget.NewChecker(b))
+	}
+
+	if display != nil {
+		display.StartBuilding()
+		runner.RegisterEventHandler(display.Handle)
+	}
+
+	var eventWriter *events.Writer
+	if eventsDest != "" {
+		w, err := events.Open(eventsDest)
 
 		if err != nil {
-			if err == io.EOF {
-				break
+			panic(err)
+		}
+		defer w.Close()
+
+		eventWriter = events.NewWriter(w, wd)
+		runner.RegisterEventHandler(eventWriter.Handle)
+	}
+
+	var outPaths *output.PathTemplate
+	if outDir != "" {
+		outPaths, err = output.NewPathTemplate(outDir, outputFileName, outTemplate)
+
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	var archiveWriter *archive.Writer
+	if archiveDest != "" {
+		format := archiveFormat
+
+		if format == "" {
+			format = archive.FormatTarGz
+
+			if archiveDest != "-" {
+				format, err = archive.DetectFormat(archiveDest)
+
+				if err != nil {
+					panic(err)
+				}
 			}
+		}
 
+		archiveWriter, err = archive.Create(archiveDest, format, fileMode)
+
+		if err != nil {
 			panic(err)
 		}
+	}
 
-		if len(line) == 0 {
-			continue
+	var ociLayout *oci.Layout
+	var ociLayer *oci.Layer
+	if ociDir != "" {
+		ociLayout, err = oci.Open(ociDir)
+
+		if err != nil {
+			panic(err)
 		}
 
-		krunner.Enqueue(filepath.Join(wd, string(line)))
+		if ociMode == ociModeCombined {
+			ociLayer = oci.NewLayer()
+		}
 	}
 
-	if !krunner.Wait() {
-		os.Exit(1)
+	if archiveWriter != nil || ociLayout != nil {
+		// アーカイブ内のパスはターゲットのパスをそのまま使う
+		outPaths, err = output.NewPathTemplate("", outputFileName, outTemplate)
+
+		if err != nil {
+			panic(err)
+		}
 	}
+
+	var signKey ed25519.PrivateKey
+	var sumsDir string
+	sums := checksum.New()
+	if checksumsFile != "" {
+		sumsDir, err = filepath.Abs(filepath.Dir(checksumsFile))
+
+		if err != nil {
+			panic(err)
+		}
+
+		// マニフェストは最後に書くので、書けないことが全部のビルドの後に分からないようにする
+		if err := os.MkdirAll(sumsDir, 0777); err != nil {
+			panic(err)
+		}
+
+		if signKeyFile != "" {
+			signKey, err = checksum.LoadPrivateKey(signKeyFile)
+
+			if err != nil {
+				panic(err)
+			}
+		}
+	}
+
+	manifest := prune.NewManifest(wd)
+	// -out-templateによっては別のターゲットが同じパスになるので、上書きせずに失敗させる
+	writtenBy := map[string]string{}
+	stdout := bufio.NewWriter(os.Stdout)
+	firstArtifact := true
+
+	runner.RegisterCallback(func(dir string, resMap resmap.ResMap) (krunner.Output, error) {
+		if outputFileName == "-" {
+			// JSONはそのまま連結すればストリームとして読めるので、区切りはYAMLだけ
+			if formats[0] == output.FormatYAML {
+				if !firstArtifact {
+					stdout.WriteString("---\n")
+				}
+				firstArtifact = false
+
+				fmt.Fprintf(stdout, "# Source: %s\n", relPath(wd, dir))
+			}
+
+			dw := output.NewDigestWriter(stdout)
+
+			if err := formats[0].Write(dw, output.File{Resources: resMap.Resources()}); err != nil {
+				return krunner.Output{}, err
+			}
+
+			return krunner.Output{Bytes: dw.Size(), SHA256: dw.SHA256()}, stdout.Flush()
+		}
+
+		fileName := filepath.Join(dir, outputFileName)
+
+		if outPaths != nil {
+			var err error
+			fileName, err = outPaths.Path(relPath(wd, dir))
+
+			if err != nil {
+				return krunner.Output{}, err
+			}
+		}
+
+		files, err := output.Split(layout, fileName, resMap)
+
+		if err != nil {
+			return krunner.Output{}, err
+		}
+
+		if showDiff {
+			if err := printDiff(wd, dir, layout, fileName, resMap); err != nil {
+				return krunner.Output{}, err
+			}
+		}
+
+		target := relPath(wd, dir)
+
+		if ociLayer != nil {
+			return writeArtifact(fileName, files, func(path string, write func(io.Writer) error) (output.FileResult, error) {
+				return ociLayer.WriteFile(path, fileMode, write)
+			})
+		}
+
+		if ociLayout != nil {
+			return writeOCITarget(ociLayout, target, fileName, files)
+		}
+
+		if archiveWriter != nil {
+			return writeArtifact(fileName, files, func(path string, write func(io.Writer) error) (output.FileResult, error) {
+				return archiveWriter.WriteFile(path, target, fileMode, write)
+			})
+		}
+
+		out, err := writeArtifact(fileName, files, func(path string, write func(io.Writer) error) (output.FileResult, error) {
+			if other, ok := writtenBy[path]; ok && other != target {
+				return output.FileResult{}, fmt.Errorf("%s is already written by %s", path, other)
+			}
+			writtenBy[path] = target
+
+			if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+				return output.FileResult{}, err
+			}
+
+			res, err := output.WriteFile(path, fileMode, write)
+
+			if err != nil {
+				return res, err
+			}
+
+			manifest.Add(target, manifestPath(wd, path))
+
+			if checksumsFile != "" {
+				p, err := sumsPath(sumsDir, path)
+
+				if err != nil {
+					return res, err
+				}
+				sums.Add(p, res.SHA256)
+			}
+
+			return res, nil
+		})
+
+		if err != nil || layout == output.LayoutSingle {
+			return out, err
+		}
+
+		// 削除されたリソースのファイルが残るとデプロイされ続けるので消す。
+		// 他のターゲットがこの実行で書いたファイルは残す
+		removed, err := output.RemoveStale(fileName, func(path string) bool {
+			_, ok := writtenBy[path]
+
+			return ok
+		})
+
+		for _, p := range removed {
+			log.Printf("removed %s (%s)", relPath(wd, p), target)
+		}
+
+		return out, err
+	})
+
+	targets, err := readTargets(os.Stdin, wd)
+
+	if err != nil {
+		panic(err)
+	}
+
+	hist := &history.History{Durations: map[string]float64{}}
+	if stateDir != "" {
+		hist, err = history.Load(stateDir)
+
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	rels := relPaths(wd, targets)
+
+	if shardSpec != "" {
+		s, err := shard.Parse(shardSpec)
+
+		if err != nil {
+			panic(err)
+		}
+
+		switch shardMode {
+		case shard.ModeHash:
+			rels = s.SelectHash(rels)
+		case shard.ModeBalanced:
+			durations, err := history.LoadFile(shardDurations)
+
+			if err != nil {
+				panic(err)
+			}
+
+			digest, err := checksum.HashFile(shardDurations)
+
+			if err != nil {
+				panic(err)
+			}
+
+			// 全shardで同じ値になっていることをログで比べられるようにする
+			log.Printf("balancing shards by %s (sha256 %s)", shardDurations, digest)
+			rels = s.SelectBalanced(rels, durations.Estimate)
+		}
+	}
+
+	switch schedule {
+	case "longest-first":
+		// 入力順に出力するので、並べ替えると待ちが長くなるだけ
+		if ordered {
+			break
+		}
+
+		hist.LongestFirst(rels)
+	case "input":
+	default:
+		panic(fmt.Sprintf("unknown schedule: %s", schedule))
+	}
+
+	targets = absPaths(wd, rels)
+
+	for _, t := range targets {
+		runner.Enqueue(t)
+	}
+
+	succeeded := runner.Wait()
+
+	if archiveWriter != nil {
+		if err := archiveWriter.Close(); err != nil {
+			log.Printf("failed to write archive: %v", err)
+			succeeded = false
+		}
+	}
+
+	if ociLayout != nil {
+		if err := closeOCILayout(ociLayout, ociLayer); err != nil {
+			log.Printf("failed to write OCI image layout: %v", err)
+			succeeded = false
+		}
+	}
+
+	if checksumsFile != "" {
+		if err := writeChecksums(sums, sumsDir, signKey); err != nil {
+			log.Printf("failed to write checksums: %v", err)
+			succeeded = false
+		}
+	}
+
+	if display != nil {
+		display.Close()
+		log.SetOutput(os.Stderr)
+	}
+
+	if eventWriter != nil {
+		if err := eventWriter.Err(); err != nil {
+			log.Printf("failed to write events: %v", err)
+		}
+	}
+
+	if stateDir != "" {
+		for _, res := range runner.Results() {
+			if res.Err == nil {
+				hist.Record(relPath(wd, res.Dir), res.BuildDuration)
+			}
+		}
+
+		if err := hist.Save(stateDir); err != nil {
+			log.Printf("failed to save history: %v", err)
+		}
+
+		// ファイルに書かない場合は記録する出力がないので、前回の記録を残しておく
+		if outputFileName != "-" && archiveDest == "" && ociDir == "" {
+			if err := updateManifest(wd, manifest, runner.Results()); err != nil {
+				log.Printf("failed to update output manifest: %v", err)
+				succeeded = false
+			}
+		}
+	}
+
+	if tracer != nil {
+		if err := tracer.WriteFile(traceFile, traceFormat); err != nil {
+			panic(err)
+		}
+	}
+
+	if reportFile != "" || junitFile != "" {
+		options := map[string]string{}
+		flag.VisitAll(func(f *flag.Flag) {
+			options[f.Name] = f.Value.String()
+		})
+
+		r := report.New(wd, startedAt, options, runner.Results())
+
+		if reportFile != "" {
+			if err := r.WriteFile(reportFile); err != nil {
+				panic(err)
+			}
+		}
+
+		if junitFile != "" {
+			if err := r.WriteJUnit(junitFile); err != nil {
+				panic(err)
+			}
+		}
+	}
+
+	return succeeded
+}
+
+func newProgressDisplay(wd string) *progress.Display {
+	mode := progressMode
+
+	if mode == "auto" {
+		// stdoutに他の出力がある場合は描画が崩れるのでplainにする
+		if progress.IsTerminal(os.Stdout) && len(stdoutUsers()) == 0 {
+			mode = "tty"
+		} else {
+			mode = "plain"
+		}
+	}
+
+	switch mode {
+	case "tty":
+		d := progress.New(os.Stdout, true, wd)
+		log.SetOutput(d)
+
+		return d
+	case "plain":
+		return progress.New(os.Stderr, false, wd)
+	case "none":
+		return nil
+	default:
+		panic(fmt.Sprintf("unknown progress mode: %s", progressMode))
+	}
+}
+
+// stdoutUsers returns the options which write to stdout.
+// Their outputs would be interleaved if more than one is given.
+func stdoutUsers() []string {
+	var users []string
+
+	if outputFileName == "-" {
+		users = append(users, "-o -")
+	}
+	if archiveDest == "-" {
+		users = append(users, "-archive -")
+	}
+	if events.IsStdout(eventsDest) {
+		users = append(users, "-events "+eventsDest)
+	}
+	if showDiff {
+		users = append(users, "-diff")
+	}
+	if progressMode == "tty" {
+		users = append(users, "-progress tty")
+	}
+
+	return users
+}
+
+// manifestPath returns path relative to wd if it is inside wd.
+func manifestPath(wd, path string) string {
+	rel, err := filepath.Rel(wd, path)
+
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return path
+	}
+
+	return rel
+}
+
+// updateManifest records the outputs of this run, and prunes the outputs of
+// previous runs which no target produced if requested.
+func updateManifest(wd string, manifest *prune.Manifest, results []krunner.Result) error {
+	old, err := prune.Load(stateDir)
+
+	if err != nil {
+		return err
+	}
+
+	if err := manifest.CheckRoot(old); err != nil {
+		if pruneOutputs || pruneDryRun {
+			return fmt.Errorf("refusing to prune: %w (run without -prune once to record the outputs of this workspace)", err)
+		}
+
+		// 別のワークスペースの記録は使えないので、この実行の出力だけを記録し直す
+		log.Printf("discarding the output manifest: %v", err)
+
+		return manifest.Save(stateDir)
+	}
+
+	// 失敗したターゲットの出力は消さずに残しておく
+	for _, res := range results {
+		if res.Err != nil {
+			target := relPath(wd, res.Dir)
+
+			if outputs, ok := old.Outputs[target]; ok {
+				manifest.Outputs[target] = outputs
+			}
+		}
+	}
+
+	stale := manifest.Stale(old)
+	targets := make([]string, 0, len(stale))
+	for target := range stale {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+
+	for _, target := range targets {
+		outputs := stale[target]
+
+		if pruneOutputs && !pruneDryRun {
+			if err := prune.Remove(wd, outputs); err != nil {
+				return err
+			}
+
+			for _, o := range outputs {
+				log.Printf("pruned %s (%s)", o, target)
+			}
+
+			continue
+		}
+
+		if pruneDryRun {
+			for _, o := range outputs {
+				log.Printf("would prune %s (%s)", o, target)
+			}
+		}
+
+		// 消していない出力は次回のpruneのために覚えておく
+		for _, o := range outputs {
+			manifest.Add(target, o)
+		}
+	}
+
+	return manifest.Save(stateDir)
+}
+
+// writeArtifact writes the files of the artifact at fileName in each format with writeFile.
+// If more than one file is written, the digest of the artifact is the digest of a
+// sha256sum style listing of the files relative to the directory of fileName.
+func writeArtifact(fileName string, files []output.File, writeFile func(path string, write func(io.Writer) error) (output.FileResult, error)) (krunner.Output, error) {
+	var out krunner.Output
+	var sums strings.Builder
+	count := 0
+
+	for _, f := range files {
+		for _, format := range formats {
+			path := format.Path(f.Path)
+
+			res, err := writeFile(path, func(w io.Writer) error {
+				return format.Write(w, f)
+			})
+
+			if err != nil {
+				return krunner.Output{}, err
+			}
+
+			out.Bytes += res.Bytes
+			out.SHA256 = res.SHA256
+			count++
+
+			rel, _ := filepath.Rel(filepath.Dir(fileName), path)
+			fmt.Fprintf(&sums, "%s  %s\n", res.SHA256, filepath.ToSlash(rel))
+		}
+	}
+
+	if count != 1 {
+		dw := output.NewDigestWriter(io.Discard)
+		io.WriteString(dw, sums.String())
+		out.SHA256 = dw.SHA256()
+	}
+
+	return out, nil
+}
+
+// newCRDValidator collects CRDs in paths. A kustomization directory is rendered
+// to collect CRDs, and other directories are searched for YAML and JSON files.
+func newCRDValidator(paths []string) (*schema.CRDValidator, error) {
+	v := schema.NewCRDValidator()
+	fs := filesys.MakeFsOnDisk()
+
+	for _, p := range paths {
+		if !isKustomization(p) {
+			if err := v.AddPath(p); err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
+		resMap, err := newKustomizer().Run(fs, p)
+
+		if err != nil {
+			return nil, fmt.Errorf("kustomize for CRDs in %s failed: %w", p, err)
+		}
+
+		if err := v.AddResources(resMap); err != nil {
+			return nil, err
+		}
+	}
+
+	return v, nil
+}
+
+func isKustomization(dir string) bool {
+	for _, name := range konfig.RecognizedKustomizationFileNames() {
+		if fi, err := os.Stat(filepath.Join(dir, name)); err == nil && fi.Mode().IsRegular() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sumsPath returns path relative to the directory of the checksum manifest,
+// which is where verify resolves the paths from.
+func sumsPath(sumsDir, path string) (string, error) {
+	dir, err := filepath.Abs(sumsDir)
+
+	if err != nil {
+		return "", err
+	}
+
+	abs, err := filepath.Abs(path)
+
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Rel(dir, abs)
+}
+
+// writeChecksums adds the archive and the OCI image layout to sums, which already has
+// the artifact files, writes the manifest and signs it if key is not nil.
+func writeChecksums(sums *checksum.Sums, sumsDir string, key ed25519.PrivateKey) error {
+	if archiveDest != "" {
+		path, err := filepath.Abs(archiveDest)
+
+		if err != nil {
+			return err
+		}
+
+		digest, err := checksum.HashFile(path)
+
+		if err != nil {
+			return err
+		}
+
+		p, err := sumsPath(sumsDir, path)
+
+		if err != nil {
+			return err
+		}
+		sums.Add(p, digest)
+	}
+
+	if ociDir != "" {
+		root, err := filepath.Abs(ociDir)
+
+		if err != nil {
+			return err
+		}
+
+		err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || !d.Type().IsRegular() {
+				return err
+			}
+
+			digest, err := checksum.HashFile(path)
+
+			if err != nil {
+				return err
+			}
+
+			p, err := sumsPath(sumsDir, path)
+
+			if err != nil {
+				return err
+			}
+			sums.Add(p, digest)
+
+			return nil
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	content := sums.Bytes()
+
+	_, err := output.WriteFile(checksumsFile, 0644, func(w io.Writer) error {
+		_, err := w.Write(content)
+
+		return err
+	})
+
+	if err != nil || key == nil {
+		return err
+	}
+
+	_, err = output.WriteFile(checksumsFile+checksum.SignatureSuffix, 0644, func(w io.Writer) error {
+		_, err := w.Write(checksum.Sign(key, content))
+
+		return err
+	})
+
+	return err
+}
+
+// ociAnnotations returns the manifest annotations of the target, or of the whole set if target is empty.
+func ociAnnotations(target string) map[string]string {
+	annotations := map[string]string{}
+
+	if ociSource != "" {
+		annotations[oci.AnnotationSource] = ociSource
+	}
+	if ociRevision != "" {
+		annotations[oci.AnnotationRevision] = ociRevision
+	}
+	if target != "" {
+		annotations[oci.AnnotationTarget] = filepath.ToSlash(target)
+	}
+
+	return annotations
+}
+
+// writeOCITarget writes the artifact of the target as an image tagged with the target path.
+// Files in the layer are relative to the directory of fileName.
+func writeOCITarget(layout *oci.Layout, target, fileName string, files []output.File) (krunner.Output, error) {
+	layer := oci.NewLayer()
+
+	out, err := writeArtifact(fileName, files, func(path string, write func(io.Writer) error) (output.FileResult, error) {
+		rel, err := filepath.Rel(filepath.Dir(fileName), path)
+
+		if err != nil {
+			return output.FileResult{}, err
+		}
+
+		return layer.WriteFile(rel, fileMode, write)
+	})
+
+	if err != nil {
+		return krunner.Output{}, err
+	}
+
+	if err := addOCIManifest(layout, layer, oci.RefName(target), target); err != nil {
+		return krunner.Output{}, err
+	}
+
+	return out, nil
+}
+
+func addOCIManifest(layout *oci.Layout, layer *oci.Layer, ref, target string) error {
+	content, err := layer.Close()
+
+	if err != nil {
+		return err
+	}
+
+	desc, err := layout.WriteBlob(oci.MediaTypeContent, content)
+
+	if err != nil {
+		return err
+	}
+	desc.Annotations = map[string]string{oci.AnnotationTitle: strings.ReplaceAll(ref, "/", "_") + ".tar.gz"}
+
+	_, err = layout.AddManifest(ref, []oci.Descriptor{desc}, ociAnnotations(target))
+
+	return err
+}
+
+// closeOCILayout adds the image of all targets with -oci-mode combined and writes the index.
+func closeOCILayout(layout *oci.Layout, combined *oci.Layer) error {
+	if combined != nil {
+		if err := addOCIManifest(layout, combined, ociTag, ""); err != nil {
+			return err
+		}
+	}
+
+	return layout.Close()
+}
+
+// readPreviousArtifact reads the artifact written by the previous run.
+// With a split layout, all YAML files in the split directory are concatenated.
+func readPreviousArtifact(layout output.Layout, fileName string) ([]byte, error) {
+	if layout == output.LayoutSingle {
+		b, err := os.ReadFile(fileName)
+
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+
+		return b, err
+	}
+
+	var buf bytes.Buffer
+	err := filepath.WalkDir(output.SplitDir(fileName), func(path string, d fs.DirEntry, err error) error {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() || filepath.Ext(path) != ".yaml" {
+			return nil
+		}
+
+		b, err := os.ReadFile(path)
+
+		if err != nil {
+			return err
+		}
+
+		buf.WriteString("---\n")
+		buf.Write(b)
+
+		return nil
+	})
+
+	return buf.Bytes(), err
+}
+
+func printDiff(wd, dir string, layout output.Layout, fileName string, resMap resmap.ResMap) error {
+	old, err := readPreviousArtifact(layout, fileName)
+
+	if err != nil {
+		return fmt.Errorf("failed to read previous artifact: %w", err)
+	}
+
+	rel := relPath(wd, dir)
+
+	oldNodes, err := semdiff.Parse(old)
+
+	if err != nil {
+		log.Printf("diff for %s failed: %v", rel, err)
+		return nil
+	}
+
+	newNodes := make([]*kyaml.RNode, 0, resMap.Size())
+	for _, res := range resMap.Resources() {
+		newNodes = append(newNodes, &res.RNode)
+	}
+
+	changes := semdiff.Diff(oldNodes, newNodes)
+
+	for _, c := range changes {
+		for _, line := range c.Lines() {
+			fmt.Printf("%s: %s\n", rel, line)
+		}
+	}
+
+	return nil
 }
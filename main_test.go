@@ -0,0 +1,141 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tsuzu/kachtomize/pkg/checksum"
+	"github.com/tsuzu/kachtomize/pkg/output"
+)
+
+// chdir changes the working directory until the end of the test.
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+
+	wd, err := os.Getwd()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.Chdir(wd)
+	})
+}
+
+func TestChecksumsWithRelativeOutDir(t *testing.T) {
+	chdir(t, t.TempDir())
+
+	outPaths, err := output.NewPathTemplate("out2", "artifact.yaml", output.DefaultPathTemplate)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sumsFile := filepath.Join("sums", "SHA256SUMS")
+	sumsDir, err := filepath.Abs(filepath.Dir(sumsFile))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sums := checksum.New()
+	for _, target := range []string{"a", "b/c"} {
+		path, err := outPaths.Path(target)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+		if filepath.IsAbs(path) {
+			t.Fatalf("%s is absolute, want a path relative to the working directory", path)
+		}
+
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		res, err := output.WriteFile(path, 0644, func(w io.Writer) error {
+			_, err := io.WriteString(w, "kind: ConfigMap\n")
+
+			return err
+		})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		p, err := sumsPath(sumsDir, path)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+		sums.Add(p, res.SHA256)
+	}
+
+	if err := os.MkdirAll(sumsDir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(sumsFile, sums.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := verify(sumsFile, "", ""); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifySignedManifestRequiresKey(t *testing.T) {
+	dir := t.TempDir()
+
+	artifact := filepath.Join(dir, "artifact.yaml")
+	if err := os.WriteFile(artifact, []byte("kind: ConfigMap\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	digest, err := checksum.HashFile(artifact)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sums := checksum.New()
+	sums.Add("artifact.yaml", digest)
+	sumsFile := filepath.Join(dir, "SHA256SUMS")
+	if err := os.WriteFile(sumsFile, sums.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := verify(sumsFile, "", ""); err != nil {
+		t.Fatalf("unsigned manifest: %v", err)
+	}
+
+	pub, priv, err := ed25519.GenerateKey(nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(pub)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(dir, "key.pub.pem")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(sumsFile+checksum.SignatureSuffix, checksum.Sign(priv, sums.Bytes()), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := verify(sumsFile, "", ""); err == nil {
+		t.Error("signed manifest verified without -key")
+	}
+	if err := verify(sumsFile, keyFile, ""); err != nil {
+		t.Errorf("signed manifest with -key: %v", err)
+	}
+}
@@ -0,0 +1,277 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tsuzu/kachtomize/pkg/output"
+)
+
+const (
+	FormatTarGz = "tar.gz"
+	FormatZip   = "zip"
+)
+
+// ManifestName is the name of the digest manifest at the root of archives.
+const ManifestName = "manifest.json"
+
+// modTime is the modification time of all entries so that archives of the same
+// outputs are identical. zip cannot represent times before 1980.
+var modTime = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// DetectFormat returns the archive format of the file name by its extension.
+func DetectFormat(name string) (string, error) {
+	switch {
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return FormatTarGz, nil
+	case strings.HasSuffix(name, ".zip"):
+		return FormatZip, nil
+	default:
+		return "", fmt.Errorf("unknown archive format of %s: must end with .tar.gz, .tgz or .zip", name)
+	}
+}
+
+// Manifest lists the files in an archive with their digests.
+type Manifest struct {
+	Files []Entry `json:"files"`
+}
+
+type Entry struct {
+	Path   string `json:"path"`
+	Target string `json:"target"`
+	Bytes  int64  `json:"bytes"`
+	SHA256 string `json:"sha256"`
+}
+
+// Writer writes files into a tar.gz or zip archive.
+// It is not safe for concurrent use.
+type Writer struct {
+	dest string
+	mode os.FileMode
+	file *os.File
+	bw   *bufio.Writer
+
+	gz  *gzip.Writer
+	tw  *tar.Writer
+	zw  *zip.Writer
+	buf bytes.Buffer
+
+	manifest Manifest
+	names    map[string]bool
+}
+
+// Create creates an archive at dest, or writes it to stdout if dest is "-".
+// A file is written to a temporary file and renamed over dest with mode by Close.
+// The directory of dest is created if it does not exist.
+func Create(dest, format string, mode os.FileMode) (*Writer, error) {
+	a := &Writer{
+		dest:  dest,
+		mode:  mode,
+		names: map[string]bool{ManifestName: true},
+	}
+
+	var w io.Writer = os.Stdout
+	if dest != "-" {
+		if err := os.MkdirAll(filepath.Dir(dest), 0777); err != nil {
+			return nil, fmt.Errorf("failed to create directory for %s: %w", dest, err)
+		}
+
+		f, err := os.CreateTemp(filepath.Dir(dest), "."+filepath.Base(dest)+".tmp-*")
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to create temporary file for %s: %w", dest, err)
+		}
+
+		a.file = f
+		w = f
+	}
+
+	a.bw = bufio.NewWriter(w)
+
+	switch format {
+	case FormatTarGz:
+		a.gz = gzip.NewWriter(a.bw)
+		a.tw = tar.NewWriter(a.gz)
+	case FormatZip:
+		a.zw = zip.NewWriter(a.bw)
+	default:
+		a.abort()
+		return nil, fmt.Errorf("unknown archive format %q: must be tar.gz or zip", format)
+	}
+
+	return a, nil
+}
+
+// WriteFile adds a file of the target at name, a slash-separated path relative to the archive root.
+// The content is buffered so that a failed write never leaves a partial entry.
+func (a *Writer) WriteFile(name, target string, mode os.FileMode, write func(w io.Writer) error) (output.FileResult, error) {
+	name = path.Clean(filepath.ToSlash(name))
+
+	if name == "." || name == ".." || strings.HasPrefix(name, "../") || path.IsAbs(name) {
+		return output.FileResult{}, fmt.Errorf("%s is outside of the archive root", name)
+	}
+	if a.names[name] {
+		return output.FileResult{}, fmt.Errorf("%s is already in the archive", name)
+	}
+
+	a.buf.Reset()
+	dw := output.NewDigestWriter(&a.buf)
+
+	if err := write(dw); err != nil {
+		return output.FileResult{}, err
+	}
+
+	if err := a.add(name, mode, a.buf.Bytes()); err != nil {
+		return output.FileResult{}, err
+	}
+	a.names[name] = true
+
+	result := output.FileResult{
+		Bytes:  dw.Size(),
+		SHA256: dw.SHA256(),
+	}
+
+	a.manifest.Files = append(a.manifest.Files, Entry{
+		Path:   name,
+		Target: target,
+		Bytes:  result.Bytes,
+		SHA256: result.SHA256,
+	})
+
+	return result, nil
+}
+
+func (a *Writer) add(name string, mode os.FileMode, content []byte) error {
+	if a.tw != nil {
+		err := a.tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     name,
+			Mode:     int64(mode.Perm()),
+			Size:     int64(len(content)),
+			ModTime:  modTime,
+		})
+
+		if err != nil {
+			return fmt.Errorf("failed to write %s to archive: %w", name, err)
+		}
+
+		if _, err := a.tw.Write(content); err != nil {
+			return fmt.Errorf("failed to write %s to archive: %w", name, err)
+		}
+
+		return nil
+	}
+
+	h := &zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: modTime,
+	}
+	h.SetMode(mode.Perm())
+
+	w, err := a.zw.CreateHeader(h)
+
+	if err != nil {
+		return fmt.Errorf("failed to write %s to archive: %w", name, err)
+	}
+
+	if _, err := w.Write(content); err != nil {
+		return fmt.Errorf("failed to write %s to archive: %w", name, err)
+	}
+
+	return nil
+}
+
+// Close writes the manifest and finishes the archive.
+func (a *Writer) Close() error {
+	sort.Slice(a.manifest.Files, func(i, j int) bool {
+		return a.manifest.Files[i].Path < a.manifest.Files[j].Path
+	})
+	if a.manifest.Files == nil {
+		a.manifest.Files = []Entry{}
+	}
+
+	b, err := json.MarshalIndent(a.manifest, "", "  ")
+
+	if err != nil {
+		a.abort()
+		return fmt.Errorf("failed to marshal archive manifest: %w", err)
+	}
+
+	if err := a.add(ManifestName, 0644, append(b, '\n')); err != nil {
+		a.abort()
+		return err
+	}
+
+	if err := a.finish(); err != nil {
+		a.abort()
+		return err
+	}
+
+	if a.file == nil {
+		return nil
+	}
+
+	// CreateTempは0600で作るので、公開するファイルとしてのパーミッションにする
+	if err := a.file.Chmod(a.mode); err != nil {
+		a.abort()
+		return fmt.Errorf("failed to chmod %s: %w", a.file.Name(), err)
+	}
+
+	if err := a.file.Sync(); err != nil {
+		a.abort()
+		return fmt.Errorf("failed to sync %s: %w", a.file.Name(), err)
+	}
+
+	if err := a.file.Close(); err != nil {
+		os.Remove(a.file.Name())
+		return fmt.Errorf("failed to close %s: %w", a.file.Name(), err)
+	}
+
+	if err := os.Rename(a.file.Name(), a.dest); err != nil {
+		os.Remove(a.file.Name())
+		return fmt.Errorf("failed to rename %s to %s: %w", a.file.Name(), a.dest, err)
+	}
+
+	return nil
+}
+
+func (a *Writer) finish() error {
+	if a.tw != nil {
+		if err := a.tw.Close(); err != nil {
+			return fmt.Errorf("failed to finish archive: %w", err)
+		}
+
+		if err := a.gz.Close(); err != nil {
+			return fmt.Errorf("failed to finish archive: %w", err)
+		}
+	} else if err := a.zw.Close(); err != nil {
+		return fmt.Errorf("failed to finish archive: %w", err)
+	}
+
+	if err := a.bw.Flush(); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+
+	return nil
+}
+
+// abort removes the temporary file.
+func (a *Writer) abort() {
+	if a.file != nil {
+		a.file.Close()
+		os.Remove(a.file.Name())
+	}
+}
@@ -0,0 +1,67 @@
+package archive
+
+import (
+	"archive/zip"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCreateInMissingDirectory(t *testing.T) {
+	dest := filepath.Join(t.TempDir(), "out", "nested", "rendered.zip")
+
+	a, err := Create(dest, FormatZip, 0640)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = a.WriteFile("app/artifact.yaml", "app", 0644, func(w io.Writer) error {
+		_, err := io.WriteString(w, "kind: ConfigMap\n")
+
+		return err
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := os.Stat(dest)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0640 {
+		t.Errorf("mode = %#o, want 0640", fi.Mode().Perm())
+	}
+
+	r, err := zip.OpenReader(dest)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	var names []string
+	for _, f := range r.File {
+		names = append(names, f.Name)
+	}
+	if want := []string{"app/artifact.yaml", ManifestName}; !reflect.DeepEqual(names, want) {
+		t.Errorf("entries = %v, want %v", names, want)
+	}
+
+	// 一時ファイルが残っていないこと
+	entries, err := os.ReadDir(filepath.Dir(dest))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("%d files in %s, want only the archive", len(entries), filepath.Dir(dest))
+	}
+}
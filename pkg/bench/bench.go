@@ -0,0 +1,148 @@
+package bench
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"runtime"
+	"sort"
+	"time"
+
+	"github.com/tsuzu/kachtomize/pkg/fsloader"
+	"github.com/tsuzu/kachtomize/pkg/fsutil"
+	"github.com/tsuzu/kachtomize/pkg/output"
+	"sigs.k8s.io/kustomize/api/krusty"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+type Mode string
+
+const (
+	ModeDisk    Mode = "disk"
+	ModeInMemFS Mode = "inmemfs"
+)
+
+type Options struct {
+	KustomizerInit func() *krusty.Kustomizer
+	Targets        []string
+	LoadDirs       []string
+	LoadJobs       int
+	Iterations     int
+	Modes          []Mode
+}
+
+// Stat is the statistics of a target in a mode.
+// Allocations are averaged over iterations.
+type Stat struct {
+	Target     string
+	Mode       Mode
+	P50        time.Duration
+	P95        time.Duration
+	AllocBytes uint64
+	Allocs     uint64
+}
+
+type Result struct {
+	Stats []Stat
+
+	// LoadTimes is the time to prepare the file system of each mode.
+	LoadTimes map[Mode]time.Duration
+}
+
+// Run builds all targets sequentially Iterations times in each mode.
+// Targets are built one by one so that allocations can be attributed to each target.
+func Run(opts Options) (*Result, error) {
+	result := &Result{
+		LoadTimes: map[Mode]time.Duration{},
+	}
+
+	for _, mode := range opts.Modes {
+		started := time.Now()
+		fSys, err := prepare(mode, opts.LoadDirs, opts.LoadJobs)
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to prepare %s: %w", mode, err)
+		}
+		result.LoadTimes[mode] = time.Since(started)
+
+		durations := make([][]time.Duration, len(opts.Targets))
+		allocBytes := make([]uint64, len(opts.Targets))
+		allocObjects := make([]uint64, len(opts.Targets))
+
+		for i := 0; i < opts.Iterations; i++ {
+			for j, target := range opts.Targets {
+				var before, after runtime.MemStats
+
+				runtime.ReadMemStats(&before)
+				started := time.Now()
+
+				if err := build(opts.KustomizerInit, fSys, target); err != nil {
+					return nil, fmt.Errorf("%s build for %s failed: %w", mode, target, err)
+				}
+
+				durations[j] = append(durations[j], time.Since(started))
+				runtime.ReadMemStats(&after)
+
+				allocBytes[j] += after.TotalAlloc - before.TotalAlloc
+				allocObjects[j] += after.Mallocs - before.Mallocs
+			}
+		}
+
+		for j, target := range opts.Targets {
+			result.Stats = append(result.Stats, Stat{
+				Target:     target,
+				Mode:       mode,
+				P50:        percentile(durations[j], 0.50),
+				P95:        percentile(durations[j], 0.95),
+				AllocBytes: allocBytes[j] / uint64(opts.Iterations),
+				Allocs:     allocObjects[j] / uint64(opts.Iterations),
+			})
+		}
+	}
+
+	return result, nil
+}
+
+func prepare(mode Mode, loadDirs []string, loadJobs int) (filesys.FileSystem, error) {
+	switch mode {
+	case ModeDisk:
+		return filesys.MakeFsOnDisk(), nil
+	case ModeInMemFS:
+		fSys := fsutil.MakeFsInMemory()
+
+		if err := fsloader.New(fSys).LoadAll(loadDirs, loadJobs); err != nil {
+			return nil, err
+		}
+
+		return fsutil.NewReadOnlyFS(fSys), nil
+	default:
+		return nil, fmt.Errorf("unknown mode: %s", mode)
+	}
+}
+
+func build(kustomizerInit func() *krusty.Kustomizer, fSys filesys.FileSystem, dir string) error {
+	resMap, err := kustomizerInit().Run(fSys, dir)
+
+	if err != nil {
+		return err
+	}
+
+	return output.WriteYAML(io.Discard, resMap)
+}
+
+// percentile returns the q-th percentile with the nearest-rank method.
+func percentile(durations []time.Duration, q float64) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+
+	sorted := append([]time.Duration(nil), durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	rank := int(math.Ceil(q*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+
+	return sorted[rank]
+}
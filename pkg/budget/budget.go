@@ -0,0 +1,123 @@
+package budget
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/tsuzu/kachtomize/pkg/check"
+	"sigs.k8s.io/kustomize/api/resmap"
+)
+
+// DefaultResourceBytes is the default limit of a single resource, about the
+// 1.5 MiB request size limit of etcd minus room for annotations such as
+// kubectl.kubernetes.io/last-applied-configuration and the status.
+const DefaultResourceBytes = 1 << 20
+
+// separator is written between resources in a YAML artifact.
+const separator = "---\n"
+
+// maxListed is the number of the largest resources listed when the artifact is over the budget.
+const maxListed = 5
+
+// Budget is the limits of a target. Zero means unlimited.
+// Sizes are measured in the YAML serialization of the resources.
+type Budget struct {
+	ArtifactBytes int64
+	ResourceBytes int64
+	Resources     int
+}
+
+// IsZero returns true if nothing is limited.
+func (b Budget) IsZero() bool {
+	return b == Budget{}
+}
+
+// Checker reports targets over the budget as errors.
+type Checker struct {
+	budget Budget
+}
+
+var _ check.Checker = &Checker{}
+
+func NewChecker(b Budget) *Checker {
+	return &Checker{budget: b}
+}
+
+func (c *Checker) Name() string {
+	return "budget"
+}
+
+type sized struct {
+	id    string
+	bytes int64
+}
+
+func (c *Checker) Check(resMap resmap.ResMap) ([]check.Finding, error) {
+	var findings []check.Finding
+
+	resources := resMap.Resources()
+	sizes := make([]sized, 0, len(resources))
+
+	var total int64
+	for i, res := range resources {
+		b, err := res.AsYAML()
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to serialize %s: %w", res.CurId(), err)
+		}
+
+		size := sized{id: check.ResourceID(res), bytes: int64(len(b))}
+		sizes = append(sizes, size)
+
+		total += size.bytes
+		if i != 0 {
+			total += int64(len(separator))
+		}
+
+		if c.budget.ResourceBytes > 0 && size.bytes > c.budget.ResourceBytes {
+			findings = append(findings, check.Finding{
+				Check:    c.Name(),
+				Severity: check.SeverityError,
+				Resource: size.id,
+				Message:  fmt.Sprintf("resource is %d bytes, over the budget of %d bytes", size.bytes, c.budget.ResourceBytes),
+			})
+		}
+	}
+
+	if c.budget.Resources > 0 && len(resources) > c.budget.Resources {
+		findings = append(findings, check.Finding{
+			Check:    c.Name(),
+			Severity: check.SeverityError,
+			Message:  fmt.Sprintf("target has %d resources, over the budget of %d", len(resources), c.budget.Resources),
+		})
+	}
+
+	if c.budget.ArtifactBytes > 0 && total > c.budget.ArtifactBytes {
+		findings = append(findings, check.Finding{
+			Check:    c.Name(),
+			Severity: check.SeverityError,
+			Message:  fmt.Sprintf("artifact is %d bytes, over the budget of %d bytes; largest resources: %s", total, c.budget.ArtifactBytes, largest(sizes)),
+		})
+	}
+
+	return findings, nil
+}
+
+// largest lists the largest resources to point at the ones to trim.
+func largest(sizes []sized) string {
+	sort.SliceStable(sizes, func(i, j int) bool {
+		return sizes[i].bytes > sizes[j].bytes
+	})
+
+	if len(sizes) > maxListed {
+		sizes = sizes[:maxListed]
+	}
+
+	list := make([]string, 0, len(sizes))
+	for _, s := range sizes {
+		list = append(list, fmt.Sprintf("%s (%d bytes)", s.id, s.bytes))
+	}
+
+	return strings.Join(list, ", ")
+}
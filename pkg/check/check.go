@@ -0,0 +1,66 @@
+package check
+
+import (
+	"fmt"
+	"strings"
+
+	"sigs.k8s.io/kustomize/api/resmap"
+	"sigs.k8s.io/kustomize/api/resource"
+)
+
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// Finding is a problem found in the rendered resources of a target.
+type Finding struct {
+	Check    string   `json:"check"`
+	Severity Severity `json:"severity"`
+	// Resource is the ID of the resource, or empty for findings about the whole target.
+	Resource string `json:"resource,omitempty"`
+	// Field is the path of the field such as spec.template.spec.containers[name=app].image.
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+func (f Finding) String() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "%s [%s]", f.Severity, f.Check)
+	if f.Resource != "" {
+		fmt.Fprintf(&b, " %s", f.Resource)
+	}
+	if f.Field != "" {
+		fmt.Fprintf(&b, " %s", f.Field)
+	}
+	fmt.Fprintf(&b, ": %s", f.Message)
+
+	return b.String()
+}
+
+// Checker checks the rendered resources of a target.
+// Check is called from multiple workers at once and must be safe for concurrent use.
+type Checker interface {
+	Name() string
+	Check(resMap resmap.ResMap) ([]Finding, error)
+}
+
+// ResourceID returns the ID of res used in findings.
+func ResourceID(res *resource.Resource) string {
+	return res.CurId().String()
+}
+
+// CountErrors returns the number of error findings.
+func CountErrors(findings []Finding) int {
+	n := 0
+	for _, f := range findings {
+		if f.Severity == SeverityError {
+			n++
+		}
+	}
+
+	return n
+}
@@ -0,0 +1,135 @@
+package checksum
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Sums is a SHA256SUMS style manifest of files.
+// Paths are slash-separated and relative to the directory of the manifest.
+type Sums struct {
+	digests map[string]string
+}
+
+func New() *Sums {
+	return &Sums{
+		digests: map[string]string{},
+	}
+}
+
+// Add records the hex encoded SHA-256 digest of the file at path.
+func (s *Sums) Add(path, digest string) {
+	s.digests[filepath.ToSlash(path)] = digest
+}
+
+// Len returns the number of files.
+func (s *Sums) Len() int {
+	return len(s.digests)
+}
+
+func (s *Sums) paths() []string {
+	paths := make([]string, 0, len(s.digests))
+	for p := range s.digests {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	return paths
+}
+
+// Bytes returns the manifest in the format of sha256sum, sorted by path.
+func (s *Sums) Bytes() []byte {
+	var buf bytes.Buffer
+	for _, p := range s.paths() {
+		fmt.Fprintf(&buf, "%s  %s\n", s.digests[p], p)
+	}
+
+	return buf.Bytes()
+}
+
+// Parse parses a manifest in the format of sha256sum.
+// Both the text ("  ") and binary (" *") separators are accepted.
+func Parse(b []byte) (*Sums, error) {
+	s := New()
+
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+
+		if line == "" {
+			continue
+		}
+
+		if len(line) < 67 || (line[64:66] != "  " && line[64:66] != " *") {
+			return nil, fmt.Errorf("invalid checksum line %d", n)
+		}
+
+		digest := line[:64]
+		if _, err := hex.DecodeString(digest); err != nil {
+			return nil, fmt.Errorf("invalid checksum line %d: %w", n, err)
+		}
+
+		s.Add(line[66:], strings.ToLower(digest))
+	}
+
+	return s, scanner.Err()
+}
+
+// HashFile returns the hex encoded SHA-256 digest of the file.
+func HashFile(path string) (string, error) {
+	f, err := os.Open(path)
+
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Mismatch is a file which does not match the manifest.
+type Mismatch struct {
+	Path string
+	Err  error
+}
+
+func (m Mismatch) Error() string {
+	return fmt.Sprintf("%s: %v", m.Path, m.Err)
+}
+
+// Verify checks the files in the manifest relative to dir and returns the ones
+// which are missing or have different content.
+func (s *Sums) Verify(dir string) []Mismatch {
+	var mismatches []Mismatch
+
+	for _, p := range s.paths() {
+		path := filepath.FromSlash(p)
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+
+		digest, err := HashFile(path)
+
+		switch {
+		case err != nil:
+			mismatches = append(mismatches, Mismatch{Path: p, Err: err})
+		case digest != s.digests[p]:
+			mismatches = append(mismatches, Mismatch{Path: p, Err: fmt.Errorf("checksum mismatch")})
+		}
+	}
+
+	return mismatches
+}
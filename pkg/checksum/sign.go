@@ -0,0 +1,98 @@
+package checksum
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// SignatureSuffix is appended to the manifest path to get the path of its detached signature.
+const SignatureSuffix = ".sig"
+
+// LoadPrivateKey loads an ed25519 private key in PKCS #8 PEM,
+// such as the one generated by openssl genpkey -algorithm ed25519.
+func LoadPrivateKey(path string) (ed25519.PrivateKey, error) {
+	der, err := readPEM(path, "PRIVATE KEY")
+
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(der)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key %s: %w", path, err)
+	}
+
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an ed25519 private key", path)
+	}
+
+	return priv, nil
+}
+
+// LoadPublicKey loads an ed25519 public key in PKIX PEM,
+// such as the one extracted by openssl pkey -pubout.
+func LoadPublicKey(path string) (ed25519.PublicKey, error) {
+	der, err := readPEM(path, "PUBLIC KEY")
+
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := x509.ParsePKIXPublicKey(der)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key %s: %w", path, err)
+	}
+
+	pub, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an ed25519 public key", path)
+	}
+
+	return pub, nil
+}
+
+func readPEM(path, blockType string) ([]byte, error) {
+	b, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key: %w", err)
+	}
+
+	block, _ := pem.Decode(b)
+	if block == nil || block.Type != blockType {
+		return nil, fmt.Errorf("%s has no %s PEM block", path, blockType)
+	}
+
+	return block.Bytes, nil
+}
+
+// Sign returns a base64 encoded detached signature of data.
+func Sign(key ed25519.PrivateKey, data []byte) []byte {
+	sig := ed25519.Sign(key, data)
+
+	return []byte(base64.StdEncoding.EncodeToString(sig) + "\n")
+}
+
+// VerifySignature verifies a detached signature created by Sign.
+func VerifySignature(key ed25519.PublicKey, data, sig []byte) error {
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig)))
+
+	if err != nil {
+		return fmt.Errorf("invalid signature: %w", err)
+	}
+
+	if !ed25519.Verify(key, data, decoded) {
+		return errors.New("signature mismatch")
+	}
+
+	return nil
+}
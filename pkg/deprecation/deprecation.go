@@ -0,0 +1,204 @@
+package deprecation
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/tsuzu/kachtomize/pkg/check"
+	"sigs.k8s.io/kustomize/api/resmap"
+)
+
+// Version is a Kubernetes minor version such as 1.29.
+type Version struct {
+	Major int
+	Minor int
+}
+
+// ParseVersion parses versions such as "1.29", "v1.29" and "1.29.3". The patch version is ignored.
+func ParseVersion(s string) (Version, error) {
+	parts := strings.Split(strings.TrimPrefix(s, "v"), ".")
+
+	if len(parts) < 2 || len(parts) > 3 {
+		return Version{}, fmt.Errorf("invalid Kubernetes version %q: must be like 1.29", s)
+	}
+
+	major, err := strconv.Atoi(parts[0])
+
+	if err != nil {
+		return Version{}, fmt.Errorf("invalid Kubernetes version %q: %w", s, err)
+	}
+
+	minor, err := strconv.Atoi(parts[1])
+
+	if err != nil {
+		return Version{}, fmt.Errorf("invalid Kubernetes version %q: %w", s, err)
+	}
+
+	return Version{Major: major, Minor: minor}, nil
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d", v.Major, v.Minor)
+}
+
+// AtLeast returns true if v is o or later.
+func (v Version) AtLeast(o Version) bool {
+	if v.Major != o.Major {
+		return v.Major > o.Major
+	}
+
+	return v.Minor >= o.Minor
+}
+
+func (v Version) isZero() bool {
+	return v == Version{}
+}
+
+// API is a deprecated apiVersion and kind.
+type API struct {
+	APIVersion string
+	Kind       string
+	Deprecated Version
+	// Removed is zero if the API is not scheduled for removal.
+	Removed Version
+	// Replacement is the apiVersion and kind to migrate to, or empty if there is none.
+	Replacement string
+}
+
+func (a API) suggestion() string {
+	if a.Replacement == "" {
+		return "there is no replacement"
+	}
+
+	return "use " + a.Replacement
+}
+
+func v1(minor int) Version {
+	return Version{Major: 1, Minor: minor}
+}
+
+// APIs is based on the Kubernetes deprecated API migration guide.
+var APIs = []API{
+	{"extensions/v1beta1", "DaemonSet", v1(8), v1(16), "apps/v1 DaemonSet"},
+	{"extensions/v1beta1", "Deployment", v1(8), v1(16), "apps/v1 Deployment"},
+	{"extensions/v1beta1", "ReplicaSet", v1(8), v1(16), "apps/v1 ReplicaSet"},
+	{"extensions/v1beta1", "NetworkPolicy", v1(9), v1(16), "networking.k8s.io/v1 NetworkPolicy"},
+	{"extensions/v1beta1", "PodSecurityPolicy", v1(10), v1(16), ""},
+	{"apps/v1beta1", "Deployment", v1(9), v1(16), "apps/v1 Deployment"},
+	{"apps/v1beta1", "StatefulSet", v1(9), v1(16), "apps/v1 StatefulSet"},
+	{"apps/v1beta2", "DaemonSet", v1(9), v1(16), "apps/v1 DaemonSet"},
+	{"apps/v1beta2", "Deployment", v1(9), v1(16), "apps/v1 Deployment"},
+	{"apps/v1beta2", "ReplicaSet", v1(9), v1(16), "apps/v1 ReplicaSet"},
+	{"apps/v1beta2", "StatefulSet", v1(9), v1(16), "apps/v1 StatefulSet"},
+
+	{"admissionregistration.k8s.io/v1beta1", "MutatingWebhookConfiguration", v1(16), v1(22), "admissionregistration.k8s.io/v1 MutatingWebhookConfiguration"},
+	{"admissionregistration.k8s.io/v1beta1", "ValidatingWebhookConfiguration", v1(16), v1(22), "admissionregistration.k8s.io/v1 ValidatingWebhookConfiguration"},
+	{"apiextensions.k8s.io/v1beta1", "CustomResourceDefinition", v1(16), v1(22), "apiextensions.k8s.io/v1 CustomResourceDefinition"},
+	{"apiregistration.k8s.io/v1beta1", "APIService", v1(19), v1(22), "apiregistration.k8s.io/v1 APIService"},
+	{"authentication.k8s.io/v1beta1", "TokenReview", v1(19), v1(22), "authentication.k8s.io/v1 TokenReview"},
+	{"authorization.k8s.io/v1beta1", "LocalSubjectAccessReview", v1(19), v1(22), "authorization.k8s.io/v1 LocalSubjectAccessReview"},
+	{"authorization.k8s.io/v1beta1", "SelfSubjectAccessReview", v1(19), v1(22), "authorization.k8s.io/v1 SelfSubjectAccessReview"},
+	{"authorization.k8s.io/v1beta1", "SubjectAccessReview", v1(19), v1(22), "authorization.k8s.io/v1 SubjectAccessReview"},
+	{"certificates.k8s.io/v1beta1", "CertificateSigningRequest", v1(19), v1(22), "certificates.k8s.io/v1 CertificateSigningRequest"},
+	{"coordination.k8s.io/v1beta1", "Lease", v1(19), v1(22), "coordination.k8s.io/v1 Lease"},
+	{"extensions/v1beta1", "Ingress", v1(14), v1(22), "networking.k8s.io/v1 Ingress"},
+	{"networking.k8s.io/v1beta1", "Ingress", v1(19), v1(22), "networking.k8s.io/v1 Ingress"},
+	{"networking.k8s.io/v1beta1", "IngressClass", v1(19), v1(22), "networking.k8s.io/v1 IngressClass"},
+	{"rbac.authorization.k8s.io/v1beta1", "ClusterRole", v1(17), v1(22), "rbac.authorization.k8s.io/v1 ClusterRole"},
+	{"rbac.authorization.k8s.io/v1beta1", "ClusterRoleBinding", v1(17), v1(22), "rbac.authorization.k8s.io/v1 ClusterRoleBinding"},
+	{"rbac.authorization.k8s.io/v1beta1", "Role", v1(17), v1(22), "rbac.authorization.k8s.io/v1 Role"},
+	{"rbac.authorization.k8s.io/v1beta1", "RoleBinding", v1(17), v1(22), "rbac.authorization.k8s.io/v1 RoleBinding"},
+	{"scheduling.k8s.io/v1beta1", "PriorityClass", v1(14), v1(22), "scheduling.k8s.io/v1 PriorityClass"},
+	{"storage.k8s.io/v1beta1", "CSIDriver", v1(19), v1(22), "storage.k8s.io/v1 CSIDriver"},
+	{"storage.k8s.io/v1beta1", "CSINode", v1(17), v1(22), "storage.k8s.io/v1 CSINode"},
+	{"storage.k8s.io/v1beta1", "StorageClass", v1(19), v1(22), "storage.k8s.io/v1 StorageClass"},
+	{"storage.k8s.io/v1beta1", "VolumeAttachment", v1(19), v1(22), "storage.k8s.io/v1 VolumeAttachment"},
+
+	{"batch/v1beta1", "CronJob", v1(21), v1(25), "batch/v1 CronJob"},
+	{"discovery.k8s.io/v1beta1", "EndpointSlice", v1(21), v1(25), "discovery.k8s.io/v1 EndpointSlice"},
+	{"events.k8s.io/v1beta1", "Event", v1(19), v1(25), "events.k8s.io/v1 Event"},
+	{"autoscaling/v2beta1", "HorizontalPodAutoscaler", v1(22), v1(25), "autoscaling/v2 HorizontalPodAutoscaler"},
+	{"policy/v1beta1", "PodDisruptionBudget", v1(21), v1(25), "policy/v1 PodDisruptionBudget"},
+	{"policy/v1beta1", "PodSecurityPolicy", v1(21), v1(25), ""},
+	{"node.k8s.io/v1beta1", "RuntimeClass", v1(20), v1(25), "node.k8s.io/v1 RuntimeClass"},
+
+	{"flowcontrol.apiserver.k8s.io/v1beta1", "FlowSchema", v1(23), v1(26), "flowcontrol.apiserver.k8s.io/v1 FlowSchema"},
+	{"flowcontrol.apiserver.k8s.io/v1beta1", "PriorityLevelConfiguration", v1(23), v1(26), "flowcontrol.apiserver.k8s.io/v1 PriorityLevelConfiguration"},
+	{"autoscaling/v2beta2", "HorizontalPodAutoscaler", v1(23), v1(26), "autoscaling/v2 HorizontalPodAutoscaler"},
+
+	{"storage.k8s.io/v1beta1", "CSIStorageCapacity", v1(24), v1(27), "storage.k8s.io/v1 CSIStorageCapacity"},
+
+	{"flowcontrol.apiserver.k8s.io/v1beta2", "FlowSchema", v1(26), v1(29), "flowcontrol.apiserver.k8s.io/v1 FlowSchema"},
+	{"flowcontrol.apiserver.k8s.io/v1beta2", "PriorityLevelConfiguration", v1(26), v1(29), "flowcontrol.apiserver.k8s.io/v1 PriorityLevelConfiguration"},
+
+	{"flowcontrol.apiserver.k8s.io/v1beta3", "FlowSchema", v1(29), v1(32), "flowcontrol.apiserver.k8s.io/v1 FlowSchema"},
+	{"flowcontrol.apiserver.k8s.io/v1beta3", "PriorityLevelConfiguration", v1(29), v1(32), "flowcontrol.apiserver.k8s.io/v1 PriorityLevelConfiguration"},
+
+	{"v1", "ComponentStatus", v1(19), Version{}, ""},
+	{"v1", "Endpoints", v1(33), Version{}, "discovery.k8s.io/v1 EndpointSlice"},
+}
+
+type key struct {
+	apiVersion string
+	kind       string
+}
+
+// Checker reports resources of APIs deprecated or removed in a Kubernetes version.
+type Checker struct {
+	version  Version
+	severity check.Severity
+	apis     map[key]API
+}
+
+var _ check.Checker = &Checker{}
+
+// NewChecker returns a checker for the Kubernetes version.
+// Findings have the severity, so that SeverityError fails the targets.
+func NewChecker(version Version, severity check.Severity) *Checker {
+	apis := make(map[key]API, len(APIs))
+	for _, api := range APIs {
+		apis[key{api.APIVersion, api.Kind}] = api
+	}
+
+	return &Checker{
+		version:  version,
+		severity: severity,
+		apis:     apis,
+	}
+}
+
+func (c *Checker) Name() string {
+	return "deprecation"
+}
+
+func (c *Checker) Check(resMap resmap.ResMap) ([]check.Finding, error) {
+	var findings []check.Finding
+
+	for _, res := range resMap.Resources() {
+		api, ok := c.apis[key{res.GetApiVersion(), res.GetKind()}]
+		if !ok || !c.version.AtLeast(api.Deprecated) {
+			continue
+		}
+
+		var msg string
+		switch {
+		case !api.Removed.isZero() && c.version.AtLeast(api.Removed):
+			msg = fmt.Sprintf("%s %s was removed in %s; %s", api.APIVersion, api.Kind, api.Removed, api.suggestion())
+		case !api.Removed.isZero():
+			msg = fmt.Sprintf("%s %s is deprecated since %s and will be removed in %s; %s", api.APIVersion, api.Kind, api.Deprecated, api.Removed, api.suggestion())
+		default:
+			msg = fmt.Sprintf("%s %s is deprecated since %s; %s", api.APIVersion, api.Kind, api.Deprecated, api.suggestion())
+		}
+
+		findings = append(findings, check.Finding{
+			Check:    c.Name(),
+			Severity: c.severity,
+			Resource: check.ResourceID(res),
+			Field:    "apiVersion",
+			Message:  msg,
+		})
+	}
+
+	return findings, nil
+}
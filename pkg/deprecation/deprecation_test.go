@@ -0,0 +1,18 @@
+package deprecation
+
+import "testing"
+
+func TestReplacementsAreNotRemoved(t *testing.T) {
+	removed := map[string]Version{}
+	for _, api := range APIs {
+		if !api.Removed.isZero() {
+			removed[api.APIVersion+" "+api.Kind] = api.Removed
+		}
+	}
+
+	for _, api := range APIs {
+		if v, ok := removed[api.Replacement]; ok {
+			t.Errorf("%s %s is replaced by %s, which is removed in %s", api.APIVersion, api.Kind, api.Replacement, v)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tsuzu/kachtomize/pkg/krunner"
+)
+
+type event struct {
+	Type   krunner.EventType `json:"type"`
+	Dir    string            `json:"dir"`
+	Worker int               `json:"worker,omitempty"`
+	Time   time.Time         `json:"time"`
+	Error  string            `json:"error,omitempty"`
+}
+
+// Writer writes krunner events as newline delimited JSON.
+type Writer struct {
+	root string
+
+	lock sync.Mutex
+	enc  *json.Encoder
+	err  error
+}
+
+func NewWriter(w io.Writer, root string) *Writer {
+	return &Writer{
+		root: root,
+		enc:  json.NewEncoder(w),
+	}
+}
+
+// IsStdout returns true if dest given to Open means stdout.
+func IsStdout(dest string) bool {
+	return dest == "-" || dest == "fd:1"
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// Open opens the destination of events.
+// "-" means stdout and "fd:N" means the file descriptor N.
+// Otherwise dest is a file path.
+// Closing the returned writer does not close stdout or stderr.
+func Open(dest string) (io.WriteCloser, error) {
+	if IsStdout(dest) {
+		return nopCloser{os.Stdout}, nil
+	}
+
+	if dest == "fd:2" {
+		return nopCloser{os.Stderr}, nil
+	}
+
+	if strings.HasPrefix(dest, "fd:") {
+		fd := strings.TrimPrefix(dest, "fd:")
+		n, err := strconv.Atoi(fd)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid file descriptor %q: %w", fd, err)
+		}
+
+		return os.NewFile(uintptr(n), dest), nil
+	}
+
+	f, err := os.Create(dest)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", dest, err)
+	}
+
+	return f, nil
+}
+
+// Handle writes the event. It can be passed to krunner.Runner.RegisterEventHandler.
+func (w *Writer) Handle(e krunner.Event) {
+	ev := event{
+		Type:   e.Type,
+		Dir:    e.Dir,
+		Worker: e.Worker,
+		Time:   e.Time,
+	}
+	if rel, err := filepath.Rel(w.root, e.Dir); err == nil {
+		ev.Dir = rel
+	}
+	if e.Err != nil {
+		ev.Error = e.Err.Error()
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.err != nil {
+		return
+	}
+
+	w.err = w.enc.Encode(ev)
+}
+
+// Err returns the first error occurred on writing events.
+func (w *Writer) Err() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	return w.err
+}
@@ -8,13 +8,16 @@ import (
 	"path/filepath"
 	"sync"
 
+	"github.com/tsuzu/kachtomize/pkg/trace"
 	"golang.org/x/sync/errgroup"
 	"sigs.k8s.io/kustomize/kyaml/filesys"
 )
 
 type Loader struct {
-	fSys filesys.FileSystem
-	lock sync.Mutex
+	fSys     filesys.FileSystem
+	lock     sync.Mutex
+	callback func(path string, size int)
+	tracer   *trace.Tracer
 }
 
 func New(fSys filesys.FileSystem) *Loader {
@@ -23,12 +26,26 @@ func New(fSys filesys.FileSystem) *Loader {
 	}
 }
 
+// RegisterCallback registers a function called after each file is loaded.
+// It is called from multiple goroutines and must be safe for concurrent use.
+func (l *Loader) RegisterCallback(fn func(path string, size int)) {
+	l.callback = fn
+}
+
+// SetTracer sets the tracer which records spans of each directory and file.
+func (l *Loader) SetTracer(t *trace.Tracer) {
+	l.tracer = t
+}
+
 func (l *Loader) LoadAll(dirs []string, numOfCPU int) error {
 	ch := make(chan string, 1)
 
 	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
 	var wg errgroup.Group
 	for i := 0; i < numOfCPU; i++ {
+		worker := i + 1
 		wg.Go(func() error {
 			defer cancel()
 
@@ -44,7 +61,7 @@ func (l *Loader) LoadAll(dirs []string, numOfCPU int) error {
 					}
 				}
 
-				if err := l.Load(dir); err != nil {
+				if err := l.load(dir, worker); err != nil {
 					return err
 				}
 			}
@@ -64,6 +81,13 @@ func (l *Loader) LoadAll(dirs []string, numOfCPU int) error {
 }
 
 func (l *Loader) Load(dir string) error {
+	return l.load(dir, 0)
+}
+
+func (l *Loader) load(dir string, worker int) error {
+	span := l.tracer.Start("load-dir", worker, "dir", dir)
+	defer span.Finish()
+
 	abs, err := filepath.Abs(dir)
 
 	if err != nil {
@@ -83,6 +107,9 @@ func (l *Loader) Load(dir string) error {
 			return nil
 		}
 
+		fileSpan := span.Child("load-file", "path", path)
+		defer fileSpan.Finish()
+
 		b, err := os.ReadFile(path)
 
 		if err != nil {
@@ -93,6 +120,10 @@ func (l *Loader) Load(dir string) error {
 		l.fSys.WriteFile(path, b)
 		l.lock.Unlock()
 
+		if l.callback != nil {
+			l.callback(path, len(b))
+		}
+
 		return nil
 	})
 
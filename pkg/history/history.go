@@ -0,0 +1,122 @@
+package history
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// FileName is the name of the history file in the state directory.
+const FileName = "durations.json"
+
+// smoothing is the weight of the latest duration.
+// Durations are smoothed so that a single noisy run does not reorder the queue.
+const smoothing = 0.5
+
+// History is build durations of targets recorded in previous runs.
+// Targets are keyed by their paths relative to the workspace root.
+type History struct {
+	Durations map[string]float64 `json:"durations"`
+}
+
+// Load loads the history in stateDir. It returns an empty history if there is no history yet.
+func Load(stateDir string) (*History, error) {
+	h := &History{
+		Durations: map[string]float64{},
+	}
+
+	b, err := os.ReadFile(filepath.Join(stateDir, FileName))
+
+	if errors.Is(err, os.ErrNotExist) {
+		return h, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+
+	return parse(b)
+}
+
+// LoadFile loads a history file such as durations.json in a state directory.
+// Unlike Load, the file must exist.
+func LoadFile(path string) (*History, error) {
+	b, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+
+	return parse(b)
+}
+
+func parse(b []byte) (*History, error) {
+	h := &History{}
+
+	if err := json.Unmarshal(b, h); err != nil {
+		return nil, fmt.Errorf("failed to parse history: %w", err)
+	}
+	if h.Durations == nil {
+		h.Durations = map[string]float64{}
+	}
+
+	return h, nil
+}
+
+// Save saves the history in stateDir.
+func (h *History) Save(stateDir string) error {
+	if err := os.MkdirAll(stateDir, 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	b, err := json.MarshalIndent(h, "", "  ")
+
+	if err != nil {
+		return fmt.Errorf("failed to marshal history: %w", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(stateDir, FileName), append(b, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write history: %w", err)
+	}
+
+	return nil
+}
+
+// Record records the build duration of the target.
+func (h *History) Record(target string, d time.Duration) {
+	prev, ok := h.Durations[target]
+
+	if !ok {
+		h.Durations[target] = d.Seconds()
+		return
+	}
+
+	h.Durations[target] = smoothing*d.Seconds() + (1-smoothing)*prev
+}
+
+// Estimate returns the expected build duration of the target.
+func (h *History) Estimate(target string) (time.Duration, bool) {
+	d, ok := h.Durations[target]
+
+	return time.Duration(d * float64(time.Second)), ok
+}
+
+// LongestFirst sorts targets so that longer ones are built first,
+// which shortens the total wall time when they are built in parallel.
+// Targets never built before come first in their original order
+// since they may be as long as any other target.
+func (h *History) LongestFirst(targets []string) {
+	sort.SliceStable(targets, func(i, j int) bool {
+		di, oki := h.Durations[targets[i]]
+		dj, okj := h.Durations[targets[j]]
+
+		if oki != okj {
+			return !oki
+		}
+
+		return di > dj
+	})
+}
@@ -3,111 +3,439 @@ package krunner
 import (
 	"fmt"
 	"log"
+	"runtime"
+	"runtime/metrics"
+	"strconv"
 	"sync"
 	"sync/atomic"
+	"time"
 
+	"github.com/tsuzu/kachtomize/pkg/check"
+	"github.com/tsuzu/kachtomize/pkg/trace"
 	"sigs.k8s.io/kustomize/api/krusty"
+	"sigs.k8s.io/kustomize/api/resmap"
 	"sigs.k8s.io/kustomize/kyaml/filesys"
 )
 
+type request struct {
+	dir string
+	seq int
+}
+
 type result struct {
-	dir  string
-	yaml []byte
+	dir       string
+	seq       int
+	worker    int
+	resMap    resmap.ResMap
+	findings  []check.Finding
+	err       error
+	startedAt time.Time
+	buildTime time.Duration
+}
+
+// Output is the summary of what a callback wrote for a target.
+type Output struct {
+	Bytes  int64
+	SHA256 string
+}
+
+type EventType string
+
+const (
+	EventQueued   EventType = "queued"
+	EventStarted  EventType = "started"
+	EventFinished EventType = "finished"
+	EventFailed   EventType = "failed"
+	EventWritten  EventType = "written"
+)
+
+// Event describes a state change of a target.
+// Worker is the 1-origin ID of the worker which built the target,
+// or 0 for events not related to a worker.
+type Event struct {
+	Type   EventType
+	Dir    string
+	Worker int
+	Time   time.Time
+	Err    error
+}
+
+// Result is the outcome of a target.
+type Result struct {
+	Dir       string
+	Err       error
+	StartedAt time.Time
+	Duration  time.Duration
+	Resources int
+	Bytes     int64
+	SHA256    string
+	Findings  []check.Finding
+
+	// BuildDuration is the time of kustomize build and checks on a worker.
+	// Duration also includes waiting for and running the callback.
+	BuildDuration time.Duration
 }
 
 type Runner struct {
 	kustomizerInit func() *krusty.Kustomizer
 	fSys           filesys.FileSystem
-	callback       func(dir string, data []byte)
+	callback       func(dir string, resMap resmap.ResMap) (Output, error)
+	eventHandlers  []func(Event)
+	tracer         *trace.Tracer
+	memThreshold   uint64
+	checkers       []check.Checker
+	numOfCPU       int
 
+	startOnce  sync.Once
 	callbackWg sync.WaitGroup
-	requestCh  chan string
+	inFlight   atomic.Int32
+	requestCh  chan request
+	nextSeq    int
+	window     chan struct{}
+	delivering atomic.Int64
 	resultCh   chan result
 	errCounter atomic.Int32
+	results    []Result
 }
 
 func New(kustomizerInit func() *krusty.Kustomizer, fSys filesys.FileSystem, numOfCPU int) *Runner {
 	r := &Runner{
 		kustomizerInit: kustomizerInit,
 		fSys:           fSys,
-		requestCh:      make(chan string, 1),
+		numOfCPU:       numOfCPU,
+		requestCh:      make(chan request, 1),
 		resultCh:       make(chan result, 1),
 	}
-	r.callbackWg.Add(1)
-	go r.startWorker(numOfCPU)
 
 	return r
 }
 
-func (r *Runner) startWorker(numOfCPU int) {
+// start starts the workers on the first Enqueue or Wait, so that they see
+// the configuration set by the Set and Register methods without races.
+func (r *Runner) start() {
+	r.startOnce.Do(func() {
+		r.callbackWg.Add(1)
+		go r.startWorker()
+	})
+}
+
+func (r *Runner) startWorker() {
 	go r.callCallbackWorker()
 
 	var wg sync.WaitGroup
 
-	wg.Add(numOfCPU)
-	for i := 0; i < numOfCPU; i++ {
-		go func() {
+	wg.Add(r.numOfCPU)
+	for i := 0; i < r.numOfCPU; i++ {
+		go func(id int) {
 			defer wg.Done()
-			r.worker()
-		}()
+			r.worker(id)
+		}(i + 1)
 	}
 
 	wg.Wait()
 	close(r.resultCh)
 }
 
-func (r *Runner) worker() {
-	for dir := range r.requestCh {
-		if err := r.runKustomize(dir); err != nil {
-			r.errCounter.Add(1)
-			log.Println(err)
+// SetTracer sets the tracer which records spans of each build and callback.
+// It must be called before Enqueue.
+func (r *Runner) SetTracer(t *trace.Tracer) {
+	r.tracer = t
+}
+
+// SetMemoryBudget makes workers wait before starting a new build while the heap
+// exceeds 3/4 of budget. At least one build always proceeds so that the run does not stall.
+// It must be called before Enqueue.
+func (r *Runner) SetMemoryBudget(budget uint64) {
+	r.memThreshold = budget / 4 * 3
+}
+
+const throttleInterval = 50 * time.Millisecond
+
+// gcInterval is the minimum interval of GCs forced to measure the live heap.
+const gcInterval = time.Second
+
+var heapSample = []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+var heapSampleLock sync.Mutex
+var lastGC time.Time
+
+func readHeap() uint64 {
+	metrics.Read(heapSample)
+
+	return heapSample[0].Value.Uint64()
+}
+
+// heapInUse returns the bytes of heap objects. They include garbage not swept yet,
+// so the heap is measured again after a GC if it exceeds threshold.
+func heapInUse(threshold uint64) uint64 {
+	heapSampleLock.Lock()
+	defer heapSampleLock.Unlock()
+
+	heap := readHeap()
+
+	// 待っているワーカーごとにGCしないように、間隔を空ける
+	if heap > threshold && time.Since(lastGC) >= gcInterval {
+		runtime.GC()
+		lastGC = time.Now()
+		heap = readHeap()
+	}
+
+	return heap
+}
+
+// reserve counts a target as in flight. While the heap exceeds the threshold,
+// it waits until no target is in flight, so that only one target proceeds.
+func (r *Runner) reserve(seq int) {
+	if r.memThreshold == 0 {
+		r.inFlight.Add(1)
+		return
+	}
+
+	for {
+		// 確認と加算の間に他のワーカーが入らないように、0からの加算はCASで行う
+		if r.inFlight.CompareAndSwap(0, 1) {
+			return
+		}
+
+		// 順番待ちの結果が溜まっている場合、次に渡すべきターゲットを止めると進まなくなる
+		if heapInUse(r.memThreshold) <= r.memThreshold || (r.window != nil && int64(seq) == r.delivering.Load()) {
+			r.inFlight.Add(1)
+			return
+		}
+
+		time.Sleep(throttleInterval)
+	}
+}
+
+func (r *Runner) worker(id int) {
+	for req := range r.requestCh {
+		dir := req.dir
+
+		// 結果がコールバックで処理されるまでは実行中として数える
+		r.reserve(req.seq)
+
+		r.emit(EventStarted, dir, id, nil)
+
+		res := r.runKustomize(dir, id)
+		res.seq = req.seq
+		// コールバック待ちや書き込みを含まない、ビルドとチェックだけの時間
+		res.buildTime = time.Since(res.startedAt)
+
+		if res.err != nil {
+			r.emit(EventFailed, dir, id, res.err)
+		} else {
+			r.emit(EventFinished, dir, id, nil)
 		}
+
+		r.resultCh <- res
 	}
 }
 
-func (r *Runner) runKustomize(dir string) error {
+func (r *Runner) runKustomize(dir string, worker int) result {
+	res := result{
+		dir:       dir,
+		worker:    worker,
+		startedAt: time.Now(),
+	}
+
+	span := r.tracer.Start("build", worker, "dir", dir)
+	defer span.Finish()
+
 	kustomizer := r.kustomizerInit()
 
+	runSpan := span.Child("kustomizer.Run")
 	resMap, err := kustomizer.Run(r.fSys, dir)
+	runSpan.Finish()
 
 	if err != nil {
-		return fmt.Errorf("kustomize for %s failed: %w", dir, err)
+		res.err = fmt.Errorf("kustomize for %s failed: %w", dir, err)
+		return res
 	}
 
-	b, err := resMap.AsYaml()
+	res.resMap = resMap
+	res.findings, res.err = r.runChecks(span, dir, resMap)
 
-	if err != nil {
-		return fmt.Errorf("fetching YAML for %s failed: %w", dir, err)
+	return res
+}
+
+// runChecks runs the checkers on the rendered resources.
+// The target fails if a checker fails or finds an error.
+func (r *Runner) runChecks(span *trace.Span, dir string, resMap resmap.ResMap) ([]check.Finding, error) {
+	var findings []check.Finding
+
+	for _, c := range r.checkers {
+		checkSpan := span.Child("check", "check", c.Name())
+		f, err := c.Check(resMap)
+		checkSpan.Finish()
+
+		if err != nil {
+			return findings, fmt.Errorf("check %s for %s failed: %w", c.Name(), dir, err)
+		}
+
+		for i := range f {
+			if f[i].Check == "" {
+				f[i].Check = c.Name()
+			}
+		}
+		findings = append(findings, f...)
 	}
 
-	r.resultCh <- result{
-		dir:  dir,
-		yaml: b,
+	if n := check.CountErrors(findings); n != 0 {
+		return findings, fmt.Errorf("checks for %s found %d errors", dir, n)
 	}
 
-	return nil
+	return findings, nil
 }
 
 func (r *Runner) callCallbackWorker() {
 	defer r.callbackWg.Done()
 
+	if r.window == nil {
+		for res := range r.resultCh {
+			r.deliver(res)
+		}
+
+		return
+	}
+
+	// 順番が来るまで結果を溜めておく。溜まる数はwindowで制限されている
+	pending := map[int]result{}
+	next := 0
 	for res := range r.resultCh {
-		r.callback(res.dir, res.yaml)
+		pending[res.seq] = res
+
+		for {
+			res, ok := pending[next]
+			if !ok {
+				break
+			}
+
+			delete(pending, next)
+			r.deliver(res)
+			next++
+			r.delivering.Store(int64(next))
+			<-r.window
+		}
+	}
+}
+
+func (r *Runner) deliver(res result) {
+	err := res.err
+
+	var out Output
+	if err == nil {
+		span := r.tracer.Start("callback", 0, "dir", res.dir, "worker", strconv.Itoa(res.worker))
+		var cbErr error
+		out, cbErr = r.callback(res.dir, res.resMap)
+		span.Finish()
+
+		if cbErr != nil {
+			err = fmt.Errorf("callback for %s failed: %w", res.dir, cbErr)
+			r.emit(EventFailed, res.dir, res.worker, err)
+		} else {
+			r.emit(EventWritten, res.dir, res.worker, nil)
+		}
+	}
+
+	for _, f := range res.findings {
+		log.Printf("%s: %s", res.dir, f)
+	}
+
+	if err != nil {
+		r.errCounter.Add(1)
+		log.Println(err)
 	}
+
+	result := Result{
+		Dir:       res.dir,
+		Err:       err,
+		StartedAt: res.startedAt,
+		Duration:  time.Since(res.startedAt),
+		Bytes:     out.Bytes,
+		SHA256:    out.SHA256,
+		Findings:  res.findings,
+
+		BuildDuration: res.buildTime,
+	}
+	if res.resMap != nil {
+		result.Resources = res.resMap.Size()
+	}
+	r.results = append(r.results, result)
+
+	r.inFlight.Add(-1)
 }
 
-func (r *Runner) RegisterCallback(fn func(dir string, data []byte)) {
+// RegisterCallback registers a function which writes the rendered resources of a target.
+// It is called from a single goroutine and the resources are released after it returns.
+func (r *Runner) RegisterCallback(fn func(dir string, resMap resmap.ResMap) (Output, error)) {
 	r.callback = fn
 }
 
+// RegisterChecker adds a checker run on the rendered resources of every target before the callback.
+// Checkers run on the workers in the order of registration.
+// It must be called before Enqueue.
+func (r *Runner) RegisterChecker(c check.Checker) {
+	r.checkers = append(r.checkers, c)
+}
+
+// RegisterEventHandler adds a handler called on every event.
+// Handlers are called from multiple goroutines and must be safe for concurrent use.
+// It must be called before Enqueue.
+func (r *Runner) RegisterEventHandler(fn func(Event)) {
+	r.eventHandlers = append(r.eventHandlers, fn)
+}
+
+func (r *Runner) emit(typ EventType, dir string, worker int, err error) {
+	if len(r.eventHandlers) == 0 {
+		return
+	}
+
+	e := Event{
+		Type:   typ,
+		Dir:    dir,
+		Worker: worker,
+		Time:   time.Now(),
+		Err:    err,
+	}
+	for _, fn := range r.eventHandlers {
+		fn(e)
+	}
+}
+
+// SetOrdered makes the callback receive results in the enqueue order.
+// Up to window targets are built or buffered ahead of the oldest pending one,
+// and Enqueue blocks while the window is full.
+// It must be called before Enqueue.
+func (r *Runner) SetOrdered(window int) {
+	if window < 1 {
+		window = 1
+	}
+
+	r.window = make(chan struct{}, window)
+}
+
 func (r *Runner) Enqueue(dir string) {
-	r.requestCh <- dir
+	r.start()
+
+	if r.window != nil {
+		r.window <- struct{}{}
+	}
+
+	r.emit(EventQueued, dir, 0, nil)
+	r.requestCh <- request{dir: dir, seq: r.nextSeq}
+	r.nextSeq++
 }
 
 func (r *Runner) Wait() bool {
+	r.start()
 	close(r.requestCh)
 
 	r.callbackWg.Wait()
 
 	return r.errCounter.Load() == 0
 }
+
+// Results returns the results of all targets in completion order.
+// It must be called after Wait.
+func (r *Runner) Results() []Result {
+	return r.results
+}
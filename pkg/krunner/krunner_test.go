@@ -0,0 +1,240 @@
+package krunner
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tsuzu/kachtomize/pkg/check"
+	"sigs.k8s.io/kustomize/api/krusty"
+	"sigs.k8s.io/kustomize/api/resmap"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+const testTimeout = 30 * time.Second
+
+// newTestFS returns a file system with n kustomizations generating a ConfigMap each.
+func newTestFS(t *testing.T, n int) (filesys.FileSystem, []string) {
+	t.Helper()
+
+	fSys := filesys.MakeFsInMemory()
+	dirs := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		dir := filepath.Join("/targets", fmt.Sprintf("%02d", i))
+		content := fmt.Sprintf("configMapGenerator:\n- name: cm%d\n  literals:\n  - key=%d\n", i, i)
+
+		if err := fSys.MkdirAll(dir); err != nil {
+			t.Fatal(err)
+		}
+		if err := fSys.WriteFile(filepath.Join(dir, "kustomization.yaml"), []byte(content)); err != nil {
+			t.Fatal(err)
+		}
+
+		dirs = append(dirs, dir)
+	}
+
+	return fSys, dirs
+}
+
+func newTestKustomizer() *krusty.Kustomizer {
+	return krusty.MakeKustomizer(krusty.MakeDefaultOptions())
+}
+
+// run enqueues dirs and waits, failing the test instead of hanging if the runner deadlocks.
+func run(t *testing.T, r *Runner, dirs []string) bool {
+	t.Helper()
+
+	done := make(chan bool)
+	go func() {
+		for _, dir := range dirs {
+			r.Enqueue(dir)
+		}
+		done <- r.Wait()
+	}()
+
+	select {
+	case ok := <-done:
+		return ok
+	case <-time.After(testTimeout):
+		t.Fatal("runner did not finish")
+		return false
+	}
+}
+
+func TestOrderedDelivery(t *testing.T) {
+	fSys, dirs := newTestFS(t, 20)
+
+	r := New(newTestKustomizer, fSys, 4)
+	// Newの後に設定しても順序付きで配送されること
+	r.SetOrdered(2)
+
+	var delivered []string
+	r.RegisterCallback(func(dir string, resMap resmap.ResMap) (Output, error) {
+		delivered = append(delivered, dir)
+
+		return Output{}, nil
+	})
+
+	if !run(t, r, dirs) {
+		t.Fatal("Wait() = false, want true")
+	}
+
+	if len(delivered) != len(dirs) {
+		t.Fatalf("delivered %d targets, want %d", len(delivered), len(dirs))
+	}
+	for i := range dirs {
+		if delivered[i] != dirs[i] {
+			t.Fatalf("delivered[%d] = %s, want %s", i, delivered[i], dirs[i])
+		}
+	}
+}
+
+func TestOrderedDeliveryWithFailures(t *testing.T) {
+	fSys, dirs := newTestFS(t, 6)
+	// 失敗したターゲットも順番を進めて、ウィンドウを空けること
+	dirs = append(dirs[:3], append([]string{"/missing"}, dirs[3:]...)...)
+
+	r := New(newTestKustomizer, fSys, 2)
+	r.SetOrdered(1)
+
+	var delivered []string
+	r.RegisterCallback(func(dir string, resMap resmap.ResMap) (Output, error) {
+		delivered = append(delivered, dir)
+
+		return Output{}, nil
+	})
+
+	if run(t, r, dirs) {
+		t.Fatal("Wait() = true, want false")
+	}
+
+	if len(delivered) != len(dirs)-1 {
+		t.Fatalf("delivered %d targets, want %d", len(delivered), len(dirs)-1)
+	}
+
+	results := r.Results()
+	if len(results) != len(dirs) {
+		t.Fatalf("got %d results, want %d", len(results), len(dirs))
+	}
+	for i, res := range results {
+		if res.Dir != dirs[i] {
+			t.Errorf("results[%d].Dir = %s, want %s", i, res.Dir, dirs[i])
+		}
+		if (res.Err != nil) != (res.Dir == "/missing") {
+			t.Errorf("results[%d].Err = %v", i, res.Err)
+		}
+	}
+}
+
+func TestUnorderedDelivery(t *testing.T) {
+	fSys, dirs := newTestFS(t, 10)
+
+	r := New(newTestKustomizer, fSys, 4)
+
+	seen := map[string]int{}
+	r.RegisterCallback(func(dir string, resMap resmap.ResMap) (Output, error) {
+		seen[dir] += resMap.Size()
+
+		return Output{Bytes: 1}, nil
+	})
+
+	if !run(t, r, dirs) {
+		t.Fatal("Wait() = false, want true")
+	}
+
+	for _, dir := range dirs {
+		if seen[dir] != 1 {
+			t.Errorf("%s delivered with %d resources, want 1", dir, seen[dir])
+		}
+	}
+
+	for _, res := range r.Results() {
+		if res.Resources != 1 || res.Bytes != 1 {
+			t.Errorf("%s: Resources = %d, Bytes = %d, want 1, 1", res.Dir, res.Resources, res.Bytes)
+		}
+	}
+}
+
+type errorChecker struct{}
+
+func (errorChecker) Name() string {
+	return "test"
+}
+
+func (errorChecker) Check(resMap resmap.ResMap) ([]check.Finding, error) {
+	return []check.Finding{{Severity: check.SeverityError, Message: "bad"}}, nil
+}
+
+func TestCheckErrorsSkipCallback(t *testing.T) {
+	fSys, dirs := newTestFS(t, 2)
+
+	r := New(newTestKustomizer, fSys, 1)
+	r.RegisterChecker(errorChecker{})
+
+	called := false
+	r.RegisterCallback(func(dir string, resMap resmap.ResMap) (Output, error) {
+		called = true
+
+		return Output{}, nil
+	})
+
+	if run(t, r, dirs) {
+		t.Fatal("Wait() = true, want false")
+	}
+	if called {
+		t.Error("callback was called for targets with check errors")
+	}
+
+	for _, res := range r.Results() {
+		if len(res.Findings) != 1 || res.Findings[0].Check != "test" {
+			t.Errorf("%s: Findings = %v", res.Dir, res.Findings)
+		}
+	}
+}
+
+func TestWaitWithoutTargets(t *testing.T) {
+	r := New(newTestKustomizer, filesys.MakeFsInMemory(), 2)
+	r.SetOrdered(4)
+
+	if !run(t, r, nil) {
+		t.Fatal("Wait() = false, want true")
+	}
+}
+
+func TestMemoryBudgetReservesOneTarget(t *testing.T) {
+	r := New(newTestKustomizer, filesys.MakeFsInMemory(), 4)
+	// ヒープは常に閾値を超えるので、実行中のターゲットが終わるたびに1つずつ進むこと
+	r.SetMemoryBudget(4)
+
+	const waiters = 32
+	start := make(chan struct{})
+	proceeded := make(chan int, waiters)
+	for i := 0; i < waiters; i++ {
+		go func(seq int) {
+			<-start
+			r.reserve(seq)
+			proceeded <- seq
+		}(i)
+	}
+	close(start)
+
+	for i := 0; i < waiters; i++ {
+		select {
+		case <-proceeded:
+		case <-time.After(testTimeout):
+			t.Fatal("no target proceeded")
+		}
+
+		// 全部確かめると時間がかかるので、最初の数回だけ他が進まないことを確かめる
+		if i < 3 {
+			select {
+			case seq := <-proceeded:
+				t.Fatalf("target %d proceeded while another target is in flight", seq)
+			case <-time.After(3 * throttleInterval):
+			}
+		}
+
+		r.inFlight.Add(-1)
+	}
+}
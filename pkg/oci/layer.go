@@ -0,0 +1,91 @@
+package oci
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/tsuzu/kachtomize/pkg/output"
+)
+
+// modTime is the modification time of all entries so that layers of the same outputs are identical.
+var modTime = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Layer builds a tar+gzip content layer in memory.
+// It is not safe for concurrent use.
+type Layer struct {
+	buf bytes.Buffer
+	gz  *gzip.Writer
+	tw  *tar.Writer
+
+	file  bytes.Buffer
+	names map[string]bool
+}
+
+func NewLayer() *Layer {
+	l := &Layer{
+		names: map[string]bool{},
+	}
+	l.gz = gzip.NewWriter(&l.buf)
+	l.tw = tar.NewWriter(l.gz)
+
+	return l
+}
+
+// WriteFile adds a file at name, a slash-separated path relative to the layer root.
+func (l *Layer) WriteFile(name string, mode os.FileMode, write func(w io.Writer) error) (output.FileResult, error) {
+	name = path.Clean(filepath.ToSlash(name))
+
+	if name == "." || name == ".." || strings.HasPrefix(name, "../") || path.IsAbs(name) {
+		return output.FileResult{}, fmt.Errorf("%s is outside of the layer root", name)
+	}
+	if l.names[name] {
+		return output.FileResult{}, fmt.Errorf("%s is already in the layer", name)
+	}
+
+	l.file.Reset()
+	dw := output.NewDigestWriter(&l.file)
+
+	if err := write(dw); err != nil {
+		return output.FileResult{}, err
+	}
+
+	err := l.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     int64(mode.Perm()),
+		Size:     int64(l.file.Len()),
+		ModTime:  modTime,
+	})
+
+	if err != nil {
+		return output.FileResult{}, fmt.Errorf("failed to write %s to layer: %w", name, err)
+	}
+
+	if _, err := l.tw.Write(l.file.Bytes()); err != nil {
+		return output.FileResult{}, fmt.Errorf("failed to write %s to layer: %w", name, err)
+	}
+	l.names[name] = true
+
+	return output.FileResult{Bytes: dw.Size(), SHA256: dw.SHA256()}, nil
+}
+
+// Close finishes the layer and returns its content.
+func (l *Layer) Close() ([]byte, error) {
+	if err := l.tw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finish layer: %w", err)
+	}
+
+	if err := l.gz.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finish layer: %w", err)
+	}
+
+	return l.buf.Bytes(), nil
+}
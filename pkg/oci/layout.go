@@ -0,0 +1,201 @@
+package oci
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/tsuzu/kachtomize/pkg/output"
+)
+
+const (
+	MediaTypeIndex    = "application/vnd.oci.image.index.v1+json"
+	MediaTypeManifest = "application/vnd.oci.image.manifest.v1+json"
+
+	// Flux pulls artifacts with these media types.
+	MediaTypeConfig  = "application/vnd.cncf.flux.config.v1+json"
+	MediaTypeContent = "application/vnd.cncf.flux.content.v1.tar+gzip"
+
+	AnnotationRefName  = "org.opencontainers.image.ref.name"
+	AnnotationTitle    = "org.opencontainers.image.title"
+	AnnotationSource   = "org.opencontainers.image.source"
+	AnnotationRevision = "org.opencontainers.image.revision"
+	AnnotationTarget   = "io.github.tsuzu.kachtomize.target"
+)
+
+type Descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type Manifest struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType"`
+	Config        Descriptor        `json:"config"`
+	Layers        []Descriptor      `json:"layers"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+type Index struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType"`
+	Manifests     []Descriptor `json:"manifests"`
+}
+
+// Layout is an OCI image layout directory on local disk.
+// It is not safe for concurrent use.
+type Layout struct {
+	dir   string
+	index Index
+}
+
+// Open opens the image layout at dir, creating it if it does not exist.
+// Manifests already in the layout are kept unless they are replaced by the same reference.
+func Open(dir string) (*Layout, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "blobs", "sha256"), 0777); err != nil {
+		return nil, fmt.Errorf("failed to create image layout: %w", err)
+	}
+
+	err := os.WriteFile(filepath.Join(dir, "oci-layout"), []byte(`{"imageLayoutVersion":"1.0.0"}`+"\n"), 0644)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to create image layout: %w", err)
+	}
+
+	l := &Layout{
+		dir: dir,
+		index: Index{
+			SchemaVersion: 2,
+			MediaType:     MediaTypeIndex,
+			Manifests:     []Descriptor{},
+		},
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, "index.json"))
+
+	if errors.Is(err, os.ErrNotExist) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image index: %w", err)
+	}
+
+	if err := json.Unmarshal(b, &l.index); err != nil {
+		return nil, fmt.Errorf("failed to parse image index: %w", err)
+	}
+
+	return l, nil
+}
+
+// WriteBlob writes content as a blob and returns its descriptor.
+func (l *Layout) WriteBlob(mediaType string, content []byte) (Descriptor, error) {
+	sum := sha256.Sum256(content)
+	hexDigest := hex.EncodeToString(sum[:])
+
+	_, err := output.WriteFile(filepath.Join(l.dir, "blobs", "sha256", hexDigest), 0644, func(w io.Writer) error {
+		_, err := w.Write(content)
+
+		return err
+	})
+
+	if err != nil {
+		return Descriptor{}, err
+	}
+
+	return Descriptor{
+		MediaType: mediaType,
+		Digest:    "sha256:" + hexDigest,
+		Size:      int64(len(content)),
+	}, nil
+}
+
+// AddManifest writes a manifest with the layers and tags it as ref in the index.
+func (l *Layout) AddManifest(ref string, layers []Descriptor, annotations map[string]string) (Descriptor, error) {
+	config, err := l.WriteBlob(MediaTypeConfig, []byte("{}"))
+
+	if err != nil {
+		return Descriptor{}, err
+	}
+
+	b, err := json.Marshal(Manifest{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeManifest,
+		Config:        config,
+		Layers:        layers,
+		Annotations:   annotations,
+	})
+
+	if err != nil {
+		return Descriptor{}, fmt.Errorf("failed to marshal image manifest: %w", err)
+	}
+
+	desc, err := l.WriteBlob(MediaTypeManifest, b)
+
+	if err != nil {
+		return Descriptor{}, err
+	}
+
+	desc.Annotations = map[string]string{AnnotationRefName: ref}
+	for k, v := range annotations {
+		desc.Annotations[k] = v
+	}
+
+	manifests := l.index.Manifests[:0]
+	for _, m := range l.index.Manifests {
+		if m.Annotations[AnnotationRefName] != ref {
+			manifests = append(manifests, m)
+		}
+	}
+	l.index.Manifests = append(manifests, desc)
+
+	return desc, nil
+}
+
+// Close writes the index.
+func (l *Layout) Close() error {
+	b, err := json.MarshalIndent(l.index, "", "  ")
+
+	if err != nil {
+		return fmt.Errorf("failed to marshal image index: %w", err)
+	}
+
+	_, err = output.WriteFile(filepath.Join(l.dir, "index.json"), 0644, func(w io.Writer) error {
+		_, err := w.Write(append(b, '\n'))
+
+		return err
+	})
+
+	return err
+}
+
+var invalidRefChars = regexp.MustCompile(`[^A-Za-z0-9._/-]+`)
+
+// RefName converts a target path into a reference name such as overlays/prod.
+func RefName(target string) string {
+	ref := invalidRefChars.ReplaceAllString(filepath.ToSlash(target), "-")
+
+	var components []string
+	for _, c := range strings.Split(ref, "/") {
+		// 参照名の各要素は英数字で始まり英数字で終わる必要がある
+		c = strings.Trim(c, "._-")
+
+		if c != "" {
+			components = append(components, c)
+		}
+	}
+
+	if len(components) == 0 {
+		return "root"
+	}
+
+	return strings.Join(components, "/")
+}
@@ -0,0 +1,40 @@
+package output
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+)
+
+// DigestWriter counts bytes and computes the SHA-256 digest of everything written through it.
+type DigestWriter struct {
+	w    io.Writer
+	hash hash.Hash
+	n    int64
+}
+
+func NewDigestWriter(w io.Writer) *DigestWriter {
+	return &DigestWriter{
+		w:    w,
+		hash: sha256.New(),
+	}
+}
+
+func (d *DigestWriter) Write(p []byte) (int, error) {
+	n, err := d.w.Write(p)
+	d.hash.Write(p[:n])
+	d.n += int64(n)
+
+	return n, err
+}
+
+// Size returns the number of bytes written.
+func (d *DigestWriter) Size() int64 {
+	return d.n
+}
+
+// SHA256 returns the hex encoded SHA-256 digest of the bytes written.
+func (d *DigestWriter) SHA256() string {
+	return hex.EncodeToString(d.hash.Sum(nil))
+}
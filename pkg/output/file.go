@@ -0,0 +1,123 @@
+package output
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// FileResult describes a file written by WriteFile.
+type FileResult struct {
+	Bytes  int64
+	SHA256 string
+
+	// Unchanged is true if the file already had the same content and was left untouched.
+	Unchanged bool
+}
+
+// WriteFile writes the content produced by write to path atomically.
+// The content is written to a temporary file in the same directory and renamed
+// over path, so readers never see a half-written file. If path already has
+// identical content, it is left untouched to keep its modification time.
+func WriteFile(path string, mode os.FileMode, write func(w io.Writer) error) (FileResult, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+
+	if err != nil {
+		return FileResult{}, fmt.Errorf("failed to create temporary file for %s: %w", path, err)
+	}
+
+	renamed := false
+	defer func() {
+		if !renamed {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	bw := bufio.NewWriter(tmp)
+	dw := NewDigestWriter(bw)
+
+	if err := write(dw); err != nil {
+		return FileResult{}, err
+	}
+
+	if err := bw.Flush(); err != nil {
+		return FileResult{}, fmt.Errorf("failed to write %s: %w", tmp.Name(), err)
+	}
+
+	result := FileResult{
+		Bytes:  dw.Size(),
+		SHA256: dw.SHA256(),
+	}
+
+	same, err := hasContent(path, result.Bytes, result.SHA256)
+
+	if err != nil {
+		return FileResult{}, err
+	}
+
+	if same {
+		result.Unchanged = true
+
+		// ファイルの中身が同じでもパーミッションは揃えておく
+		if err := os.Chmod(path, mode); err != nil {
+			return FileResult{}, fmt.Errorf("failed to chmod %s: %w", path, err)
+		}
+
+		return result, nil
+	}
+
+	if err := tmp.Chmod(mode); err != nil {
+		return FileResult{}, fmt.Errorf("failed to chmod %s: %w", tmp.Name(), err)
+	}
+
+	if err := tmp.Sync(); err != nil {
+		return FileResult{}, fmt.Errorf("failed to sync %s: %w", tmp.Name(), err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return FileResult{}, fmt.Errorf("failed to close %s: %w", tmp.Name(), err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return FileResult{}, fmt.Errorf("failed to rename %s to %s: %w", tmp.Name(), path, err)
+	}
+	renamed = true
+
+	return result, nil
+}
+
+// hasContent returns true if the file at path has the given size and SHA-256 digest.
+func hasContent(path string, size int64, digest string) (bool, error) {
+	f, err := os.Open(path)
+
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+
+	if err != nil {
+		return false, fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+
+	if !fi.Mode().IsRegular() || fi.Size() != size {
+		return false, nil
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return false, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)) == digest, nil
+}
@@ -0,0 +1,69 @@
+package output
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// Format is a serialization format of artifacts.
+type Format string
+
+const (
+	// FormatYAML is a multi-document YAML.
+	FormatYAML Format = "yaml"
+	// FormatJSON is a Kubernetes v1 List, or the resource itself in split layouts.
+	FormatJSON Format = "json"
+	// FormatJSONL is one compact JSON resource per line.
+	FormatJSONL Format = "jsonl"
+)
+
+// ParseFormats parses comma-separated formats such as "yaml,json".
+func ParseFormats(s string) ([]Format, error) {
+	var formats []Format
+	seen := map[Format]bool{}
+
+	for _, f := range strings.Split(s, ",") {
+		format := Format(strings.TrimSpace(f))
+
+		switch format {
+		case FormatYAML, FormatJSON, FormatJSONL:
+		default:
+			return nil, fmt.Errorf("unknown format %q: must be yaml, json or jsonl", f)
+		}
+
+		if !seen[format] {
+			seen[format] = true
+			formats = append(formats, format)
+		}
+	}
+
+	return formats, nil
+}
+
+// Path returns the path of the file in the format.
+// YAML keeps path as is, and the others replace its extension.
+func (f Format) Path(path string) string {
+	if f == FormatYAML {
+		return path
+	}
+
+	return strings.TrimSuffix(path, filepath.Ext(path)) + "." + string(f)
+}
+
+// Write writes the resources of file in the format.
+func (f Format) Write(w io.Writer, file File) error {
+	switch f {
+	case FormatJSON:
+		if file.Split {
+			return WriteJSONObject(w, file.Resources[0])
+		}
+
+		return WriteJSONList(w, file.Resources)
+	case FormatJSONL:
+		return WriteJSONLines(w, file.Resources)
+	default:
+		return WriteResourcesYAML(w, file.Resources)
+	}
+}
@@ -0,0 +1,174 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"sigs.k8s.io/kustomize/api/resource"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// WriteJSONList writes resources as an indented Kubernetes v1 List.
+func WriteJSONList(w io.Writer, resources []*resource.Resource) error {
+	if _, err := io.WriteString(w, "{\n  \"apiVersion\": \"v1\",\n  \"kind\": \"List\",\n  \"items\": ["); err != nil {
+		return err
+	}
+
+	var compact, indented bytes.Buffer
+	for i, res := range resources {
+		compact.Reset()
+		indented.Reset()
+
+		if err := encodeJSON(&compact, res.YNode()); err != nil {
+			return fmt.Errorf("failed to serialize %s: %w", res.CurId(), err)
+		}
+
+		if i != 0 {
+			indented.WriteString(",")
+		}
+		indented.WriteString("\n    ")
+
+		if err := json.Indent(&indented, compact.Bytes(), "    ", "  "); err != nil {
+			return fmt.Errorf("failed to serialize %s: %w", res.CurId(), err)
+		}
+
+		if _, err := w.Write(indented.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	closing := "]\n}\n"
+	if len(resources) != 0 {
+		closing = "\n  ]\n}\n"
+	}
+
+	_, err := io.WriteString(w, closing)
+
+	return err
+}
+
+// WriteJSONObject writes a resource as an indented JSON object.
+func WriteJSONObject(w io.Writer, res *resource.Resource) error {
+	var compact, indented bytes.Buffer
+
+	if err := encodeJSON(&compact, res.YNode()); err != nil {
+		return fmt.Errorf("failed to serialize %s: %w", res.CurId(), err)
+	}
+
+	if err := json.Indent(&indented, compact.Bytes(), "", "  "); err != nil {
+		return fmt.Errorf("failed to serialize %s: %w", res.CurId(), err)
+	}
+	indented.WriteByte('\n')
+
+	_, err := w.Write(indented.Bytes())
+
+	return err
+}
+
+// WriteJSONLines writes one compact JSON object per resource per line.
+func WriteJSONLines(w io.Writer, resources []*resource.Resource) error {
+	var buf bytes.Buffer
+	for _, res := range resources {
+		buf.Reset()
+
+		if err := encodeJSON(&buf, res.YNode()); err != nil {
+			return fmt.Errorf("failed to serialize %s: %w", res.CurId(), err)
+		}
+		buf.WriteByte('\n')
+
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// encodeJSON encodes a YAML node as compact JSON directly from the node tree.
+// Unlike RNode.MarshalJSON, it does not serialize the node to YAML and parse it
+// again. Fields keep their order in the node, which may differ from the YAML
+// output since AsYAML sorts the keys.
+func encodeJSON(buf *bytes.Buffer, n *kyaml.Node) error {
+	switch n.Kind {
+	case kyaml.DocumentNode:
+		if len(n.Content) == 0 {
+			buf.WriteString("null")
+			return nil
+		}
+
+		return encodeJSON(buf, n.Content[0])
+	case kyaml.AliasNode:
+		return encodeJSON(buf, n.Alias)
+	case kyaml.MappingNode:
+		buf.WriteByte('{')
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if i != 0 {
+				buf.WriteByte(',')
+			}
+
+			encodeString(buf, n.Content[i].Value)
+			buf.WriteByte(':')
+
+			if err := encodeJSON(buf, n.Content[i+1]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+
+		return nil
+	case kyaml.SequenceNode:
+		buf.WriteByte('[')
+		for i, c := range n.Content {
+			if i != 0 {
+				buf.WriteByte(',')
+			}
+
+			if err := encodeJSON(buf, c); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+
+		return nil
+	case kyaml.ScalarNode:
+		return encodeScalar(buf, n)
+	default:
+		return fmt.Errorf("unsupported YAML node kind %d", n.Kind)
+	}
+}
+
+func encodeScalar(buf *bytes.Buffer, n *kyaml.Node) error {
+	switch n.ShortTag() {
+	case kyaml.NodeTagNull:
+		buf.WriteString("null")
+	case kyaml.NodeTagBool, kyaml.NodeTagInt, kyaml.NodeTagFloat:
+		var v interface{}
+
+		if err := n.Decode(&v); err != nil {
+			return err
+		}
+
+		b, err := json.Marshal(v)
+
+		if err != nil {
+			return fmt.Errorf("failed to encode %q: %w", n.Value, err)
+		}
+		buf.Write(b)
+	default:
+		encodeString(buf, n.Value)
+	}
+
+	return nil
+}
+
+// encodeString writes s as a JSON string without escaping HTML characters, like kubectl.
+func encodeString(buf *bytes.Buffer, s string) {
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+
+	// Encodeは改行を付けるので取り除く
+	buf.Truncate(buf.Len() - 1)
+}
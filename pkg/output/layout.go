@@ -0,0 +1,176 @@
+package output
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"sigs.k8s.io/kustomize/api/resmap"
+	"sigs.k8s.io/kustomize/api/resource"
+)
+
+// Layout is how the resources of a target are split into files.
+type Layout string
+
+const (
+	// LayoutSingle writes all resources into one multi-document file.
+	LayoutSingle Layout = "single"
+	// LayoutResource writes one file per resource, like kustomize build -o <dir>.
+	LayoutResource Layout = "resource"
+	// LayoutNamespace writes one file per resource into a subdirectory per namespace.
+	LayoutNamespace Layout = "namespace"
+	// LayoutKind writes one file per resource into a subdirectory per kind.
+	LayoutKind Layout = "kind"
+)
+
+// clusterDir is the subdirectory for resources without a namespace in LayoutNamespace.
+const clusterDir = "_cluster"
+
+func ParseLayout(s string) (Layout, error) {
+	switch l := Layout(s); l {
+	case LayoutSingle, LayoutResource, LayoutNamespace, LayoutKind:
+		return l, nil
+	default:
+		return "", fmt.Errorf("unknown layout %q: must be single, resource, namespace or kind", s)
+	}
+}
+
+// File is an output file and the resources written into it.
+type File struct {
+	Path      string
+	Resources []*resource.Resource
+
+	// Split is true for a file of a split layout, which holds exactly one resource.
+	Split bool
+}
+
+// Split returns the files of the artifact at artifactPath in the layout.
+// LayoutSingle returns artifactPath itself. The other layouts use artifactPath
+// without its extension as a directory, e.g. artifact.yaml becomes artifact/.
+func Split(layout Layout, artifactPath string, m resmap.ResMap) ([]File, error) {
+	if layout == LayoutSingle {
+		return []File{{Path: artifactPath, Resources: m.Resources()}}, nil
+	}
+
+	dir := SplitDir(artifactPath)
+	resources := m.Resources()
+
+	names := make([]string, len(resources))
+	count := map[string]int{}
+	for i, res := range resources {
+		names[i] = path.Join(subDir(layout, res), fileName(res))
+		count[names[i]]++
+	}
+
+	// 名前が衝突するものは別namespaceの同名リソースなので、namespaceを付けて区別する
+	seen := map[string]bool{}
+	files := make([]File, 0, len(resources))
+	for i, res := range resources {
+		name := names[i]
+
+		if count[name] > 1 {
+			name = path.Join(path.Dir(name), sanitize(res.GetNamespace())+"_"+path.Base(name))
+		}
+
+		if seen[name] {
+			return nil, fmt.Errorf("%s and another resource have the same output file %s", res.CurId(), name)
+		}
+		seen[name] = true
+
+		files = append(files, File{
+			Path:      filepath.Join(dir, filepath.FromSlash(name)),
+			Resources: []*resource.Resource{res},
+			Split:     true,
+		})
+	}
+
+	return files, nil
+}
+
+// SplitDir returns the directory of the split layouts for the artifact at artifactPath.
+func SplitDir(artifactPath string) string {
+	return strings.TrimSuffix(artifactPath, filepath.Ext(artifactPath))
+}
+
+// RemoveStale removes the files under the split directory of the artifact at artifactPath
+// for which keep returns false, such as the files of resources removed from the target,
+// and the directories left empty. It returns the removed files.
+func RemoveStale(artifactPath string, keep func(path string) bool) ([]string, error) {
+	dir := SplitDir(artifactPath)
+
+	var removed, dirs []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			if path != dir {
+				dirs = append(dirs, path)
+			}
+
+			return nil
+		}
+
+		if keep(path) {
+			return nil
+		}
+
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		removed = append(removed, path)
+
+		return nil
+	})
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return removed, fmt.Errorf("failed to remove stale files in %s: %w", dir, err)
+	}
+
+	// 子のディレクトリから消す。空でないディレクトリは消えずに残る
+	for i := len(dirs) - 1; i >= 0; i-- {
+		os.Remove(dirs[i])
+	}
+
+	return removed, nil
+}
+
+// fileName returns <group>_<version>_<kind>_<name>.yaml in lower case,
+// the same name as kustomize build -o <dir>. Empty fields such as the core group are omitted.
+func fileName(res *resource.Resource) string {
+	return sanitize(strings.ToLower(res.GetGvk().StringWoEmptyField()+"_"+res.GetName())) + ".yaml"
+}
+
+func subDir(layout Layout, res *resource.Resource) string {
+	switch layout {
+	case LayoutNamespace:
+		if ns := res.GetNamespace(); ns != "" {
+			return sanitize(ns)
+		}
+
+		return clusterDir
+	case LayoutKind:
+		return sanitize(strings.ToLower(res.GetKind()))
+	default:
+		return ""
+	}
+}
+
+// sanitize replaces path separators so that a name never escapes its directory.
+func sanitize(s string) string {
+	s = strings.NewReplacer("/", "_", "\\", "_").Replace(s)
+
+	if s == "." || s == ".." {
+		return strings.Repeat("_", len(s))
+	}
+
+	return s
+}
@@ -0,0 +1,141 @@
+package output
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"sigs.k8s.io/kustomize/api/krusty"
+	"sigs.k8s.io/kustomize/api/resmap"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+const (
+	deployment = "apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: api\n"
+	service    = "apiVersion: v1\nkind: Service\nmetadata:\n  name: svc\n"
+	configMap  = "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: cfg\n"
+)
+
+func build(t *testing.T, resources string) resmap.ResMap {
+	t.Helper()
+
+	fSys := filesys.MakeFsInMemory()
+	if err := fSys.WriteFile("/app/kustomization.yaml", []byte("resources:\n- resources.yaml\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := fSys.WriteFile("/app/resources.yaml", []byte(resources)); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := krusty.MakeKustomizer(krusty.MakeDefaultOptions()).Run(fSys, "/app")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return m
+}
+
+// writeSplit writes the artifact in the layout and removes stale files like main does.
+func writeSplit(t *testing.T, layout Layout, artifactPath string, m resmap.ResMap) []string {
+	t.Helper()
+
+	files, err := Split(layout, artifactPath, m)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	written := map[string]bool{}
+	for _, f := range files {
+		if err := os.MkdirAll(filepath.Dir(f.Path), 0777); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err := WriteFile(f.Path, 0644, func(w io.Writer) error {
+			return FormatYAML.Write(w, f)
+		})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+		written[f.Path] = true
+	}
+
+	removed, err := RemoveStale(artifactPath, func(path string) bool {
+		return written[path]
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return removed
+}
+
+func TestRemoveStaleAfterResourceRemoved(t *testing.T) {
+	dir := t.TempDir()
+	artifactPath := filepath.Join(dir, "artifact.yaml")
+
+	if removed := writeSplit(t, LayoutKind, artifactPath, build(t, deployment+"---\n"+service+"---\n"+configMap)); len(removed) != 0 {
+		t.Fatalf("removed %v on the first build", removed)
+	}
+
+	serviceFile := filepath.Join(dir, "artifact", "service", "v1_service_svc.yaml")
+	if _, err := os.Stat(serviceFile); err != nil {
+		t.Fatal(err)
+	}
+
+	// Serviceを消して再ビルドすると、そのファイルとディレクトリが消えること
+	removed := writeSplit(t, LayoutKind, artifactPath, build(t, deployment+"---\n"+configMap))
+
+	if want := []string{serviceFile}; !reflect.DeepEqual(removed, want) {
+		t.Errorf("removed %v, want %v", removed, want)
+	}
+	if _, err := os.Stat(filepath.Dir(serviceFile)); !os.IsNotExist(err) {
+		t.Errorf("%s is left: %v", filepath.Dir(serviceFile), err)
+	}
+
+	for _, p := range []string{"deployment/apps_v1_deployment_api.yaml", "configmap/v1_configmap_cfg.yaml"} {
+		if _, err := os.Stat(filepath.Join(dir, "artifact", filepath.FromSlash(p))); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestRemoveStaleKeepsOtherFiles(t *testing.T) {
+	dir := t.TempDir()
+	artifactPath := filepath.Join(dir, "artifact.yaml")
+	other := filepath.Join(dir, "artifact", "other", "artifact.yaml")
+
+	if err := os.MkdirAll(filepath.Dir(other), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(other, []byte(configMap), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := RemoveStale(artifactPath, func(path string) bool {
+		return path == other
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 0 {
+		t.Errorf("removed %v", removed)
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRemoveStaleWithoutSplitDir(t *testing.T) {
+	removed, err := RemoveStale(filepath.Join(t.TempDir(), "artifact.yaml"), func(string) bool { return false })
+
+	if err != nil || len(removed) != 0 {
+		t.Errorf("RemoveStale() = %v, %v, want nothing removed", removed, err)
+	}
+}
@@ -0,0 +1,71 @@
+package output
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// DefaultPathTemplate mirrors the target directory under the output root.
+const DefaultPathTemplate = "{{.RelDir}}/{{.FileName}}"
+
+// PathData is the data passed to path templates.
+type PathData struct {
+	// RelDir is the target directory relative to the workspace root.
+	RelDir string
+	// Name is the base name of the target directory.
+	Name string
+	// FileName is the output filename given by -o.
+	FileName string
+}
+
+// PathTemplate computes artifact paths under an output root.
+type PathTemplate struct {
+	root     string
+	fileName string
+	tmpl     *template.Template
+}
+
+func NewPathTemplate(root, fileName, text string) (*PathTemplate, error) {
+	tmpl, err := template.New("path").Option("missingkey=error").Parse(text)
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid path template %q: %w", text, err)
+	}
+
+	return &PathTemplate{
+		root:     root,
+		fileName: fileName,
+		tmpl:     tmpl,
+	}, nil
+}
+
+// Path returns the artifact path of the target at relDir.
+// It fails if the path escapes the output root.
+func (p *PathTemplate) Path(relDir string) (string, error) {
+	relDir = filepath.ToSlash(filepath.Clean(relDir))
+
+	if relDir == ".." || strings.HasPrefix(relDir, "../") {
+		return "", fmt.Errorf("%s is outside of the workspace root", relDir)
+	}
+
+	var buf bytes.Buffer
+	err := p.tmpl.Execute(&buf, PathData{
+		RelDir:   relDir,
+		Name:     filepath.Base(relDir),
+		FileName: p.fileName,
+	})
+
+	if err != nil {
+		return "", fmt.Errorf("failed to execute path template: %w", err)
+	}
+
+	rel := filepath.Clean(filepath.FromSlash(buf.String()))
+	if rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) || filepath.IsAbs(rel) {
+		return "", fmt.Errorf("path %q for %s is outside of the output root", buf.String(), relDir)
+	}
+
+	return filepath.Join(p.root, rel), nil
+}
@@ -0,0 +1,40 @@
+package output
+
+import (
+	"fmt"
+	"io"
+
+	"sigs.k8s.io/kustomize/api/resmap"
+	"sigs.k8s.io/kustomize/api/resource"
+)
+
+// WriteYAML writes resources as a multi-document YAML.
+// Resources are serialized one at a time, so the memory used for the
+// serialization is bounded by the largest resource rather than the whole artifact.
+// The output is identical to resmap.ResMap.AsYaml.
+func WriteYAML(w io.Writer, m resmap.ResMap) error {
+	return WriteResourcesYAML(w, m.Resources())
+}
+
+// WriteResourcesYAML is WriteYAML for a part of the resources of a ResMap.
+func WriteResourcesYAML(w io.Writer, resources []*resource.Resource) error {
+	for i, res := range resources {
+		b, err := res.AsYAML()
+
+		if err != nil {
+			return fmt.Errorf("failed to serialize %s: %w", res.CurId(), err)
+		}
+
+		if i != 0 {
+			if _, err := io.WriteString(w, "---\n"); err != nil {
+				return err
+			}
+		}
+
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,270 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/tsuzu/kachtomize/pkg/check"
+	"go.starlark.net/resolve"
+	"go.starlark.net/starlark"
+	"sigs.k8s.io/kustomize/api/resmap"
+)
+
+// Ext is the extension of policy files searched in directories.
+const Ext = ".star"
+
+func init() {
+	// 停止しないルールを書けないように、再帰とwhileは許可しない
+	resolve.AllowLambda = true
+	resolve.AllowNestedDef = true
+	resolve.AllowFloat = true
+	resolve.AllowSet = true
+}
+
+// rule is a function registered with rule() in a policy file.
+type rule struct {
+	name     string
+	severity check.Severity
+	fn       starlark.Callable
+}
+
+// Checker runs Starlark rules on every resource of a target.
+//
+// A policy file registers rules with the built-in rule(name, fn, severity="error").
+// fn is called with each resource as a frozen dict and returns None or an empty list
+// if the resource complies, or a violation or a list of them otherwise. A violation is
+// a message string, or a dict with "message" and optionally "field" and "severity".
+type Checker struct {
+	rules []rule
+}
+
+var _ check.Checker = &Checker{}
+
+func NewChecker() *Checker {
+	return &Checker{}
+}
+
+// AddPath loads the policy file, or the policy files under the directory.
+// It must not be called after the checker starts checking.
+func (c *Checker) AddPath(path string) error {
+	return filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() || (p != path && filepath.Ext(p) != Ext) {
+			return nil
+		}
+
+		src, err := os.ReadFile(p)
+
+		if err != nil {
+			return fmt.Errorf("failed to read policy: %w", err)
+		}
+
+		return c.load(p, src)
+	})
+}
+
+func (c *Checker) load(filename string, src []byte) error {
+	var rules []rule
+	seen := map[string]bool{}
+	for _, r := range c.rules {
+		seen[r.name] = true
+	}
+
+	register := starlark.NewBuiltin("rule", func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var name, severity string
+		var fn starlark.Callable
+
+		if err := starlark.UnpackArgs(b.Name(), args, kwargs, "name", &name, "fn", &fn, "severity?", &severity); err != nil {
+			return nil, err
+		}
+
+		s, err := parseSeverity(severity)
+
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", b.Name(), err)
+		}
+
+		if seen[name] {
+			return nil, fmt.Errorf("%s: rule %q is already defined", b.Name(), name)
+		}
+		seen[name] = true
+
+		rules = append(rules, rule{name: name, severity: s, fn: fn})
+
+		return starlark.None, nil
+	})
+
+	thread := newThread(filename)
+	if _, err := starlark.ExecFile(thread, filename, src, starlark.StringDict{"rule": register}); err != nil {
+		return fmt.Errorf("failed to load policy: %s", describe(err))
+	}
+
+	// ルールは複数のワーカーから同時に呼ばれるので変更できないようにしておく
+	for _, r := range rules {
+		r.fn.Freeze()
+	}
+	c.rules = append(c.rules, rules...)
+
+	return nil
+}
+
+// Len returns the number of loaded rules.
+func (c *Checker) Len() int {
+	return len(c.rules)
+}
+
+func (c *Checker) Name() string {
+	return "policy"
+}
+
+// Check calls every rule with every resource. Findings are named after the rules.
+func (c *Checker) Check(resMap resmap.ResMap) ([]check.Finding, error) {
+	var findings []check.Finding
+
+	for _, res := range resMap.Resources() {
+		v, err := toValue(res.YNode())
+
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", check.ResourceID(res), err)
+		}
+		v.Freeze()
+
+		id := check.ResourceID(res)
+		for _, r := range c.rules {
+			thread := newThread(r.name)
+			ret, err := starlark.Call(thread, r.fn, starlark.Tuple{v}, nil)
+
+			if err != nil {
+				return nil, fmt.Errorf("rule %s failed on %s: %s", r.name, id, describe(err))
+			}
+
+			violations, err := r.violations(ret)
+
+			if err != nil {
+				return nil, fmt.Errorf("rule %s returned an invalid value for %s: %w", r.name, id, err)
+			}
+
+			for _, f := range violations {
+				f.Resource = id
+				findings = append(findings, f)
+			}
+		}
+	}
+
+	return findings, nil
+}
+
+// violations converts the return value of a rule into findings.
+func (r *rule) violations(ret starlark.Value) ([]check.Finding, error) {
+	switch ret := ret.(type) {
+	case starlark.NoneType:
+		return nil, nil
+	case *starlark.List:
+		findings := make([]check.Finding, 0, ret.Len())
+		for i := 0; i < ret.Len(); i++ {
+			f, err := r.violation(ret.Index(i))
+
+			if err != nil {
+				return nil, err
+			}
+			findings = append(findings, f)
+		}
+
+		return findings, nil
+	default:
+		f, err := r.violation(ret)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return []check.Finding{f}, nil
+	}
+}
+
+func (r *rule) violation(v starlark.Value) (check.Finding, error) {
+	f := check.Finding{
+		Check:    r.name,
+		Severity: r.severity,
+	}
+
+	switch v := v.(type) {
+	case starlark.String:
+		f.Message = string(v)
+	case *starlark.Dict:
+		var severity string
+		for _, field := range []struct {
+			key string
+			dst *string
+		}{{"message", &f.Message}, {"field", &f.Field}, {"severity", &severity}} {
+			x, ok, err := v.Get(starlark.String(field.key))
+
+			if err != nil {
+				return f, err
+			}
+			if !ok {
+				continue
+			}
+
+			s, ok := starlark.AsString(x)
+			if !ok {
+				return f, fmt.Errorf("%s of a violation must be a string, got %s", field.key, x.Type())
+			}
+			*field.dst = s
+		}
+
+		if f.Message == "" {
+			return f, errors.New("violation has no message")
+		}
+
+		if severity != "" {
+			s, err := parseSeverity(severity)
+
+			if err != nil {
+				return f, err
+			}
+			f.Severity = s
+		}
+	default:
+		return f, fmt.Errorf("violation must be a string or a dict, got %s", v.Type())
+	}
+
+	return f, nil
+}
+
+func parseSeverity(s string) (check.Severity, error) {
+	switch check.Severity(s) {
+	case "", check.SeverityError:
+		return check.SeverityError, nil
+	case check.SeverityWarning:
+		return check.SeverityWarning, nil
+	default:
+		return "", fmt.Errorf("unknown severity %q: must be error or warning", s)
+	}
+}
+
+func newThread(name string) *starlark.Thread {
+	return &starlark.Thread{
+		Name: name,
+		Print: func(thread *starlark.Thread, msg string) {
+			log.Printf("%s: %s", thread.Name, msg)
+		},
+	}
+}
+
+// describe includes the Starlark backtrace of evaluation errors.
+func describe(err error) string {
+	var evalErr *starlark.EvalError
+	if errors.As(err, &evalErr) {
+		return evalErr.Backtrace()
+	}
+
+	return err.Error()
+}
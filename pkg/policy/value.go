@@ -0,0 +1,84 @@
+package policy
+
+import (
+	"fmt"
+
+	"go.starlark.net/starlark"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// toValue converts a YAML node into Starlark values typed like the JSON form of the resource.
+func toValue(n *kyaml.Node) (starlark.Value, error) {
+	switch n.Kind {
+	case kyaml.DocumentNode:
+		if len(n.Content) == 0 {
+			return starlark.None, nil
+		}
+
+		return toValue(n.Content[0])
+	case kyaml.AliasNode:
+		return toValue(n.Alias)
+	case kyaml.MappingNode:
+		d := starlark.NewDict(len(n.Content) / 2)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			v, err := toValue(n.Content[i+1])
+
+			if err != nil {
+				return nil, err
+			}
+
+			if err := d.SetKey(starlark.String(n.Content[i].Value), v); err != nil {
+				return nil, err
+			}
+		}
+
+		return d, nil
+	case kyaml.SequenceNode:
+		elems := make([]starlark.Value, 0, len(n.Content))
+		for _, c := range n.Content {
+			v, err := toValue(c)
+
+			if err != nil {
+				return nil, err
+			}
+			elems = append(elems, v)
+		}
+
+		return starlark.NewList(elems), nil
+	case kyaml.ScalarNode:
+		return scalarValue(n)
+	default:
+		return nil, fmt.Errorf("unsupported YAML node kind %d", n.Kind)
+	}
+}
+
+func scalarValue(n *kyaml.Node) (starlark.Value, error) {
+	switch n.ShortTag() {
+	case kyaml.NodeTagNull:
+		return starlark.None, nil
+	case kyaml.NodeTagBool:
+		var b bool
+		if err := n.Decode(&b); err != nil {
+			return nil, err
+		}
+
+		return starlark.Bool(b), nil
+	case kyaml.NodeTagInt:
+		var i int64
+		if err := n.Decode(&i); err == nil {
+			return starlark.MakeInt64(i), nil
+		}
+
+		// int64に収まらない値は文字列として扱う
+		return starlark.String(n.Value), nil
+	case kyaml.NodeTagFloat:
+		var f float64
+		if err := n.Decode(&f); err != nil {
+			return nil, err
+		}
+
+		return starlark.Float(f), nil
+	default:
+		return starlark.String(n.Value), nil
+	}
+}
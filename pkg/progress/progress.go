@@ -0,0 +1,271 @@
+package progress
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tsuzu/kachtomize/pkg/krunner"
+)
+
+const refreshInterval = 200 * time.Millisecond
+
+// IsTerminal returns true if f is a character device such as a TTY.
+func IsTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+
+	if err != nil {
+		return false
+	}
+
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+type inFlight struct {
+	dir   string
+	since time.Time
+}
+
+// Display shows the progress of the load and build phases.
+// On a TTY it redraws a status block in place, otherwise it writes plain log lines.
+type Display struct {
+	out  io.Writer
+	tty  bool
+	root string
+
+	lock sync.Mutex
+
+	phase       string
+	phaseStart  time.Time
+	loadedFiles int
+	loadedBytes int64
+
+	total    int
+	done     int
+	failed   int
+	inFlight map[int]inFlight
+
+	drawnLines int
+	stop       chan struct{}
+	stopped    chan struct{}
+}
+
+// New creates a Display. If tty is true, the display is redrawn on out
+// periodically and log output should be sent through the Display with log.SetOutput.
+func New(out io.Writer, tty bool, root string) *Display {
+	d := &Display{
+		out:      out,
+		tty:      tty,
+		root:     root,
+		inFlight: map[int]inFlight{},
+		stop:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+
+	if tty {
+		go d.refresher()
+	} else {
+		close(d.stopped)
+	}
+
+	return d
+}
+
+func (d *Display) refresher() {
+	defer close(d.stopped)
+
+	ticker := time.NewTicker(refreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-ticker.C:
+			d.lock.Lock()
+			d.redraw()
+			d.lock.Unlock()
+		}
+	}
+}
+
+func (d *Display) rel(dir string) string {
+	rel, err := filepath.Rel(d.root, dir)
+
+	if err != nil {
+		return dir
+	}
+
+	return rel
+}
+
+// StartLoading marks the beginning of the load phase.
+func (d *Display) StartLoading() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.phase = "loading"
+	d.phaseStart = time.Now()
+
+	if !d.tty {
+		log.Println("loading files into memory")
+	}
+}
+
+// FileLoaded records a loaded file. It can be passed to fsloader.Loader.RegisterCallback.
+func (d *Display) FileLoaded(path string, size int) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.loadedFiles++
+	d.loadedBytes += int64(size)
+}
+
+// StartBuilding marks the beginning of the build phase.
+func (d *Display) StartBuilding() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if !d.tty && d.phase == "loading" {
+		log.Printf("loaded %d files (%s) in %s", d.loadedFiles, formatBytes(d.loadedBytes), time.Since(d.phaseStart).Round(time.Millisecond))
+	}
+
+	d.phase = "building"
+	d.phaseStart = time.Now()
+}
+
+// Handle updates the display with the event. It can be passed to krunner.Runner.RegisterEventHandler.
+func (d *Display) Handle(e krunner.Event) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	switch e.Type {
+	case krunner.EventQueued:
+		d.total++
+	case krunner.EventStarted:
+		d.inFlight[e.Worker] = inFlight{dir: e.Dir, since: e.Time}
+	case krunner.EventFinished:
+		if !d.tty {
+			log.Printf("[%d/%d] built %s in %s", d.done+d.failed+1, d.total, d.rel(e.Dir), e.Time.Sub(d.inFlight[e.Worker].since).Round(time.Millisecond))
+		}
+		delete(d.inFlight, e.Worker)
+	case krunner.EventWritten:
+		d.done++
+	case krunner.EventFailed:
+		// エラー内容はkrunnerがログに出すので、ここでは数えるだけ
+		delete(d.inFlight, e.Worker)
+		d.failed++
+	}
+}
+
+// Write prints p above the status block. It is used as the output of the log package.
+func (d *Display) Write(p []byte) (int, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if !d.tty {
+		return d.out.Write(p)
+	}
+
+	d.printAbove(string(p))
+
+	return len(p), nil
+}
+
+// Close stops refreshing and prints the summary.
+func (d *Display) Close() {
+	if d.tty {
+		close(d.stop)
+	}
+	<-d.stopped
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.clear()
+	d.drawnLines = 0
+
+	summary := fmt.Sprintf("built %d/%d targets (%d failed) in %s", d.done, d.total, d.failed, time.Since(d.phaseStart).Round(time.Millisecond))
+	if d.tty {
+		fmt.Fprintln(d.out, summary)
+	} else {
+		log.Println(summary)
+	}
+}
+
+func (d *Display) printAbove(s string) {
+	d.clear()
+	io.WriteString(d.out, s)
+	d.drawnLines = 0
+	d.redraw()
+}
+
+func (d *Display) clear() {
+	if d.drawnLines == 0 {
+		return
+	}
+
+	// カーソルを描画済みの行の先頭に戻して、そこから下を消す
+	fmt.Fprintf(d.out, "\x1b[%dA\x1b[J", d.drawnLines)
+}
+
+func (d *Display) redraw() {
+	if !d.tty || d.phase == "" {
+		return
+	}
+
+	var buf bytes.Buffer
+	elapsed := time.Since(d.phaseStart)
+
+	switch d.phase {
+	case "loading":
+		fmt.Fprintf(&buf, "Loading files: %d files (%s), %s\n", d.loadedFiles, formatBytes(d.loadedBytes), elapsed.Round(time.Second))
+	case "building":
+		completed := d.done + d.failed
+		fmt.Fprintf(&buf, "Building: %d/%d done, %d failed, %d in flight", completed, d.total, d.failed, len(d.inFlight))
+
+		if completed > 0 && elapsed > 0 {
+			throughput := float64(completed) / elapsed.Seconds()
+			eta := time.Duration(float64(d.total-completed) / throughput * float64(time.Second))
+			fmt.Fprintf(&buf, ", %.1f targets/s, ETA %s", throughput, eta.Round(time.Second))
+		}
+		buf.WriteString("\n")
+
+		workers := make([]int, 0, len(d.inFlight))
+		for w := range d.inFlight {
+			workers = append(workers, w)
+		}
+		sort.Ints(workers)
+
+		for _, w := range workers {
+			f := d.inFlight[w]
+			fmt.Fprintf(&buf, "  #%d %s (%s)\n", w, d.rel(f.dir), time.Since(f.since).Round(100*time.Millisecond))
+		}
+	}
+
+	d.clear()
+	d.out.Write(buf.Bytes())
+	d.drawnLines = strings.Count(buf.String(), "\n")
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,144 @@
+package prune
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// FileName is the name of the manifest file in the state directory.
+const FileName = "outputs.json"
+
+// Manifest records the output files written for each target.
+// Targets and outputs are paths relative to the workspace root
+// unless they are outside of it.
+type Manifest struct {
+	// Root is the absolute path of the workspace root the paths are relative to.
+	Root    string              `json:"root"`
+	Outputs map[string][]string `json:"outputs"`
+}
+
+func NewManifest(root string) *Manifest {
+	return &Manifest{
+		Root:    root,
+		Outputs: map[string][]string{},
+	}
+}
+
+// Load loads the manifest in stateDir. It returns an empty manifest if there is none yet.
+func Load(stateDir string) (*Manifest, error) {
+	m := NewManifest("")
+
+	b, err := os.ReadFile(filepath.Join(stateDir, FileName))
+
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read output manifest: %w", err)
+	}
+
+	if err := json.Unmarshal(b, m); err != nil {
+		return nil, fmt.Errorf("failed to parse output manifest: %w", err)
+	}
+	if m.Outputs == nil {
+		m.Outputs = map[string][]string{}
+	}
+
+	return m, nil
+}
+
+// Save saves the manifest in stateDir.
+func (m *Manifest) Save(stateDir string) error {
+	if err := os.MkdirAll(stateDir, 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	for _, outputs := range m.Outputs {
+		sort.Strings(outputs)
+	}
+
+	b, err := json.MarshalIndent(m, "", "  ")
+
+	if err != nil {
+		return fmt.Errorf("failed to marshal output manifest: %w", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(stateDir, FileName), append(b, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write output manifest: %w", err)
+	}
+
+	return nil
+}
+
+// Add records an output of the target.
+func (m *Manifest) Add(target, output string) {
+	m.Outputs[target] = append(m.Outputs[target], output)
+}
+
+// CheckRoot returns an error if old was recorded in another workspace root than m.
+// The relative paths in old would point at files which were not written there.
+func (m *Manifest) CheckRoot(old *Manifest) error {
+	if len(old.Outputs) == 0 || old.Root == m.Root {
+		return nil
+	}
+
+	if old.Root == "" {
+		return fmt.Errorf("%s has no workspace root", FileName)
+	}
+
+	return fmt.Errorf("%s was recorded in %s, not in %s", FileName, old.Root, m.Root)
+}
+
+// Stale returns outputs recorded in old but not in m, keyed by their targets.
+func (m *Manifest) Stale(old *Manifest) map[string][]string {
+	current := map[string]struct{}{}
+	for _, outputs := range m.Outputs {
+		for _, o := range outputs {
+			current[o] = struct{}{}
+		}
+	}
+
+	stale := map[string][]string{}
+	for target, outputs := range old.Outputs {
+		for _, o := range outputs {
+			if _, ok := current[o]; !ok {
+				stale[target] = append(stale[target], o)
+			}
+		}
+	}
+
+	return stale
+}
+
+// Remove removes the output files. root is used to resolve relative paths.
+// Directories under root left empty are removed too.
+func Remove(root string, outputs []string) error {
+	for _, o := range outputs {
+		if filepath.IsAbs(o) {
+			if err := os.Remove(o); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("failed to remove %s: %w", o, err)
+			}
+
+			continue
+		}
+
+		path := filepath.Join(root, o)
+
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove %s: %w", path, err)
+		}
+
+		for dir := filepath.Dir(path); dir != root && dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+			// 空でないディレクトリは消えないので、そこで止める
+			if os.Remove(dir) != nil {
+				break
+			}
+		}
+	}
+
+	return nil
+}
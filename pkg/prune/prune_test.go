@@ -0,0 +1,152 @@
+package prune
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeFiles(t *testing.T, root string, paths ...string) {
+	t.Helper()
+
+	for _, p := range paths {
+		path := filepath.Join(root, p)
+
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("kind: ConfigMap\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+
+	return err == nil
+}
+
+func TestSaveLoad(t *testing.T) {
+	stateDir := t.TempDir()
+
+	m := NewManifest("/ws")
+	m.Add("a", "a/artifact.yaml")
+
+	if err := m.Save(stateDir); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := Load(stateDir)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, m) {
+		t.Errorf("loaded %+v, want %+v", loaded, m)
+	}
+}
+
+func TestCheckRootFromAnotherWorkingDirectory(t *testing.T) {
+	stateDir := t.TempDir()
+	ws1, ws2 := t.TempDir(), t.TempDir()
+
+	old := NewManifest(ws1)
+	old.Add("a", "a/artifact.yaml")
+	if err := old.Save(stateDir); err != nil {
+		t.Fatal(err)
+	}
+
+	// ws2にある同じ相対パスのファイルはkachtomizeが書いたものではない
+	writeFiles(t, ws2, "a/artifact.yaml")
+
+	old, err := Load(stateDir)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewManifest(ws2)
+	if err := m.CheckRoot(old); err == nil {
+		t.Fatal("expected an error for a manifest recorded in another workspace")
+	}
+
+	if !exists(filepath.Join(ws2, "a/artifact.yaml")) {
+		t.Error("a/artifact.yaml in the other workspace was removed")
+	}
+}
+
+func TestCheckRoot(t *testing.T) {
+	withOutputs := func(root string) *Manifest {
+		m := NewManifest(root)
+		m.Add("a", "a/artifact.yaml")
+
+		return m
+	}
+
+	tests := []struct {
+		name    string
+		old     *Manifest
+		wantErr bool
+	}{
+		{name: "same root", old: withOutputs("/ws")},
+		{name: "no previous outputs", old: NewManifest("")},
+		{name: "other root", old: withOutputs("/other"), wantErr: true},
+		{name: "no root", old: withOutputs(""), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := NewManifest("/ws").CheckRoot(tt.old)
+
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CheckRoot() = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPruneChangedOutDir(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, "out1/a/artifact.yaml", "out1/b/artifact.yaml", "out2/a/artifact.yaml", "out2/b/artifact.yaml")
+
+	old := NewManifest(root)
+	old.Add("a", "out1/a/artifact.yaml")
+	old.Add("b", "out1/b/artifact.yaml")
+
+	m := NewManifest(root)
+	m.Add("a", "out2/a/artifact.yaml")
+	m.Add("b", "out2/b/artifact.yaml")
+
+	if err := m.CheckRoot(old); err != nil {
+		t.Fatal(err)
+	}
+
+	stale := m.Stale(old)
+	want := map[string][]string{
+		"a": {"out1/a/artifact.yaml"},
+		"b": {"out1/b/artifact.yaml"},
+	}
+	if !reflect.DeepEqual(stale, want) {
+		t.Fatalf("Stale() = %v, want %v", stale, want)
+	}
+
+	for _, outputs := range stale {
+		if err := Remove(root, outputs); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if exists(filepath.Join(root, "out1")) {
+		t.Error("out1 left after its artifacts were pruned")
+	}
+	for _, p := range []string{"out2/a/artifact.yaml", "out2/b/artifact.yaml"} {
+		if !exists(filepath.Join(root, p)) {
+			t.Errorf("%s was removed", p)
+		}
+	}
+	if !exists(root) {
+		t.Error("workspace root was removed")
+	}
+}
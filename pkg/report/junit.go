@@ -0,0 +1,115 @@
+package report
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/tsuzu/kachtomize/pkg/check"
+)
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Errors    int             `xml:"errors,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	Cases     []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Body    string `xml:",chardata"`
+}
+
+const junitSuiteName = "kachtomize"
+
+func seconds(f float64) string {
+	return strconv.FormatFloat(f, 'f', 3, 64)
+}
+
+// WriteJUnit writes the report as JUnit XML.
+// Each target is recorded as a testcase.
+func (r *Report) WriteJUnit(path string) error {
+	suite := junitTestSuite{
+		Name:      junitSuiteName,
+		Tests:     r.Totals.Targets,
+		Failures:  r.Totals.Failed,
+		Time:      seconds(r.WallTimeSeconds),
+		Timestamp: r.StartedAt.Format("2006-01-02T15:04:05"),
+		Cases:     make([]junitTestCase, 0, len(r.Targets)),
+	}
+
+	for _, t := range r.Targets {
+		c := junitTestCase{
+			Name:      t.Dir,
+			ClassName: junitSuiteName,
+			Time:      seconds(t.WallTimeSeconds),
+		}
+		if t.Status == StatusFailure {
+			c.Failure = &junitFailure{
+				Message: t.Error,
+				Type:    "BuildFailure",
+				Body:    t.Error,
+			}
+
+			if check.CountErrors(t.Findings) != 0 {
+				c.Failure.Type = "CheckFailure"
+			}
+		}
+
+		if len(t.Findings) != 0 {
+			lines := make([]string, 0, len(t.Findings))
+			for _, f := range t.Findings {
+				lines = append(lines, f.String())
+			}
+
+			c.SystemOut = strings.Join(lines, "\n")
+			if c.Failure != nil {
+				c.Failure.Body = t.Error + "\n" + c.SystemOut
+			}
+		}
+
+		suite.Cases = append(suite.Cases, c)
+	}
+
+	b, err := xml.MarshalIndent(junitTestSuites{
+		Name:     junitSuiteName,
+		Tests:    suite.Tests,
+		Failures: suite.Failures,
+		Time:     suite.Time,
+		Suites:   []junitTestSuite{suite},
+	}, "", "  ")
+
+	if err != nil {
+		return fmt.Errorf("failed to marshal JUnit XML: %w", err)
+	}
+
+	b = append([]byte(xml.Header), b...)
+	if err := os.WriteFile(path, append(b, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write JUnit XML to %s: %w", path, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,116 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/tsuzu/kachtomize/pkg/check"
+	"github.com/tsuzu/kachtomize/pkg/krunner"
+)
+
+const (
+	StatusSuccess = "success"
+	StatusFailure = "failure"
+)
+
+type Report struct {
+	StartedAt       time.Time         `json:"startedAt"`
+	WallTimeSeconds float64           `json:"wallTimeSeconds"`
+	Options         map[string]string `json:"options"`
+	Totals          Totals            `json:"totals"`
+	Targets         []Target          `json:"targets"`
+}
+
+type Totals struct {
+	Targets     int   `json:"targets"`
+	Succeeded   int   `json:"succeeded"`
+	Failed      int   `json:"failed"`
+	Resources   int   `json:"resources"`
+	OutputBytes int64 `json:"outputBytes"`
+	Errors      int   `json:"errors"`
+	Warnings    int   `json:"warnings"`
+
+	// TargetWallTimeSeconds is the sum of the wall time of all targets.
+	TargetWallTimeSeconds float64 `json:"targetWallTimeSeconds"`
+}
+
+type Target struct {
+	Dir             string  `json:"dir"`
+	Status          string  `json:"status"`
+	Error           string  `json:"error,omitempty"`
+	WallTimeSeconds float64 `json:"wallTimeSeconds"`
+	Resources       int     `json:"resources"`
+	OutputBytes     int64   `json:"outputBytes"`
+	OutputSHA256    string  `json:"outputSHA256,omitempty"`
+
+	Findings []check.Finding `json:"findings,omitempty"`
+}
+
+// New builds a report from the results of a run.
+// Target directories are recorded relative to root.
+func New(root string, startedAt time.Time, options map[string]string, results []krunner.Result) *Report {
+	r := &Report{
+		StartedAt:       startedAt,
+		WallTimeSeconds: time.Since(startedAt).Seconds(),
+		Options:         options,
+		Targets:         make([]Target, 0, len(results)),
+	}
+
+	for _, res := range results {
+		t := Target{
+			Dir:             relDir(root, res.Dir),
+			Status:          StatusSuccess,
+			WallTimeSeconds: res.Duration.Seconds(),
+			Resources:       res.Resources,
+			OutputBytes:     res.Bytes,
+			OutputSHA256:    res.SHA256,
+			Findings:        res.Findings,
+		}
+		if res.Err != nil {
+			t.Status = StatusFailure
+			t.Error = res.Err.Error()
+			r.Totals.Failed++
+		} else {
+			r.Totals.Succeeded++
+		}
+
+		r.Totals.Targets++
+		r.Totals.Resources += t.Resources
+		r.Totals.OutputBytes += t.OutputBytes
+		r.Totals.Errors += check.CountErrors(t.Findings)
+		r.Totals.Warnings += len(t.Findings) - check.CountErrors(t.Findings)
+		r.Totals.TargetWallTimeSeconds += t.WallTimeSeconds
+
+		r.Targets = append(r.Targets, t)
+	}
+
+	return r
+}
+
+func relDir(root, dir string) string {
+	rel, err := filepath.Rel(root, dir)
+
+	if err != nil {
+		return dir
+	}
+
+	return rel
+}
+
+// WriteFile writes the report as indented JSON.
+func (r *Report) WriteFile(path string) error {
+	b, err := json.MarshalIndent(r, "", "  ")
+
+	if err != nil {
+		return fmt.Errorf("failed to marshal report: %w", err)
+	}
+
+	if err := os.WriteFile(path, append(b, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write report to %s: %w", path, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,266 @@
+package schema
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/tsuzu/kachtomize/pkg/check"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+	"sigs.k8s.io/kustomize/api/resmap"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+const crdKind = "CustomResourceDefinition"
+
+// CRDValidator validates custom resources against the openAPIV3Schema of their
+// CustomResourceDefinitions. CRDs are collected from configured files and
+// resources, and from the rendered resources of each target itself.
+// CRDs of a target are not used for other targets, so CRDs shared across
+// targets must be added with AddPath or AddResources beforehand.
+type CRDValidator struct {
+	schemas map[kyaml.TypeMeta]*spec.Schema
+}
+
+var _ check.Checker = &CRDValidator{}
+
+func NewCRDValidator() *CRDValidator {
+	return &CRDValidator{
+		schemas: map[kyaml.TypeMeta]*spec.Schema{},
+	}
+}
+
+// AddPath adds CRDs in the YAML or JSON file, or in such files under the directory.
+// Other resources in the files are ignored.
+// It must not be called after the validator starts checking.
+func (c *CRDValidator) AddPath(path string) error {
+	return filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			return nil
+		}
+
+		// ディレクトリ内ではYAMLとJSON以外は無視するが、直接指定されたファイルはそのまま読む
+		switch filepath.Ext(p) {
+		case ".yaml", ".yml", ".json":
+		default:
+			if p != path {
+				return nil
+			}
+		}
+
+		b, err := os.ReadFile(p)
+
+		if err != nil {
+			return fmt.Errorf("failed to read CRDs: %w", err)
+		}
+
+		nodes, err := (&kio.ByteReader{Reader: bytes.NewReader(b), OmitReaderAnnotations: true}).Read()
+
+		if err != nil {
+			return fmt.Errorf("failed to parse CRDs in %s: %w", p, err)
+		}
+
+		for _, n := range nodes {
+			if n.GetKind() != crdKind {
+				continue
+			}
+
+			if err := c.add(n); err != nil {
+				return fmt.Errorf("%s: %w", p, err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// AddResources adds CRDs among the resources, such as the ones rendered from a kustomization.
+// It must not be called after the validator starts checking.
+func (c *CRDValidator) AddResources(resMap resmap.ResMap) error {
+	for _, res := range resMap.Resources() {
+		if res.GetKind() != crdKind {
+			continue
+		}
+
+		if err := c.add(&res.RNode); err != nil {
+			return fmt.Errorf("%s: %w", check.ResourceID(res), err)
+		}
+	}
+
+	return nil
+}
+
+func (c *CRDValidator) add(n *kyaml.RNode) error {
+	schemas, err := crdSchemas(n)
+
+	if err != nil {
+		return err
+	}
+
+	for t, s := range schemas {
+		c.schemas[t] = s
+	}
+
+	return nil
+}
+
+func (c *CRDValidator) Name() string {
+	return "crd"
+}
+
+// Check validates custom resources against the CRDs added beforehand and the CRDs of the target.
+// CRDs of the target take precedence.
+func (c *CRDValidator) Check(resMap resmap.ResMap) ([]check.Finding, error) {
+	var findings []check.Finding
+
+	schemas := c.schemas
+	copied := false
+	for _, res := range resMap.Resources() {
+		if res.GetKind() != crdKind {
+			continue
+		}
+
+		local, err := crdSchemas(&res.RNode)
+
+		if err != nil {
+			findings = append(findings, check.Finding{
+				Check:    c.Name(),
+				Severity: check.SeverityError,
+				Resource: check.ResourceID(res),
+				Message:  err.Error(),
+			})
+
+			continue
+		}
+
+		if !copied {
+			copied = true
+			schemas = make(map[kyaml.TypeMeta]*spec.Schema, len(c.schemas)+len(local))
+			for t, s := range c.schemas {
+				schemas[t] = s
+			}
+		}
+		for t, s := range local {
+			schemas[t] = s
+		}
+	}
+
+	v := &Validator{
+		name:    c.Name(),
+		schemas: schemas,
+	}
+
+	f, err := v.Check(resMap)
+
+	return append(findings, f...), err
+}
+
+// crdSchemas returns the schemas of the versions of a CRD.
+// Both apiextensions.k8s.io/v1 and v1beta1 are supported.
+func crdSchemas(n *kyaml.RNode) (map[kyaml.TypeMeta]*spec.Schema, error) {
+	group := lookupString(n, "spec", "group")
+	kind := lookupString(n, "spec", "names", "kind")
+
+	if group == "" || kind == "" {
+		return nil, fmt.Errorf("CRD %s has no spec.group or spec.names.kind", n.GetName())
+	}
+
+	// v1beta1では全バージョン共通のスキーマを持てる
+	common, err := n.Pipe(kyaml.Lookup("spec", "validation", "openAPIV3Schema"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []string
+	perVersion := map[string]*kyaml.RNode{}
+
+	versionsNode, err := n.Pipe(kyaml.Lookup("spec", "versions"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if versionsNode != nil {
+		elements, err := versionsNode.Elements()
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, e := range elements {
+			name := lookupString(e, "name")
+			versions = append(versions, name)
+
+			s, err := e.Pipe(kyaml.Lookup("schema", "openAPIV3Schema"))
+
+			if err != nil {
+				return nil, err
+			}
+			perVersion[name] = s
+		}
+	} else if v := lookupString(n, "spec", "version"); v != "" {
+		versions = append(versions, v)
+	}
+
+	schemas := map[kyaml.TypeMeta]*spec.Schema{}
+	for _, v := range versions {
+		node := perVersion[v]
+		if node == nil {
+			node = common
+		}
+		if node == nil {
+			continue
+		}
+
+		b, err := node.MarshalJSON()
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to serialize openAPIV3Schema of %s/%s: %w", group, v, err)
+		}
+
+		s := &spec.Schema{}
+		if err := s.UnmarshalJSON(b); err != nil {
+			return nil, fmt.Errorf("invalid openAPIV3Schema of %s/%s: %w", group, v, err)
+		}
+		addObjectFields(s)
+
+		schemas[kyaml.TypeMeta{APIVersion: group + "/" + v, Kind: kind}] = s
+	}
+
+	return schemas, nil
+}
+
+// addObjectFields adds apiVersion, kind and metadata which every object can have
+// even if the schema of a CRD omits them.
+func addObjectFields(s *spec.Schema) {
+	if len(s.Properties) == 0 {
+		return
+	}
+
+	for _, f := range []struct {
+		name string
+		typ  string
+	}{{"apiVersion", "string"}, {"kind", "string"}, {"metadata", "object"}} {
+		if _, ok := s.Properties[f.name]; !ok {
+			s.Properties[f.name] = *spec.StringProperty().Typed(f.typ, "")
+		}
+	}
+}
+
+func lookupString(n *kyaml.RNode, path ...string) string {
+	v, err := n.Pipe(kyaml.Lookup(path...))
+
+	if err != nil || v == nil {
+		return ""
+	}
+
+	return v.YNode().Value
+}
@@ -0,0 +1,178 @@
+package schema
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/tsuzu/kachtomize/pkg/check"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+	"sigs.k8s.io/kustomize/api/resmap"
+	"sigs.k8s.io/kustomize/kyaml/openapi"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+	k8syaml "sigs.k8s.io/yaml"
+)
+
+// gvkExtension lists the types a definition is the schema of.
+const gvkExtension = "x-kubernetes-group-version-kind"
+
+// Validator checks resources against the schemas of their types.
+// Unknown fields, wrong types and missing required fields are reported as errors.
+// Resources of types without a schema are skipped.
+type Validator struct {
+	name    string
+	root    *spec.Schema
+	schemas map[kyaml.TypeMeta]*spec.Schema
+
+	refs sync.Map
+}
+
+var _ check.Checker = &Validator{}
+
+// NewOpenAPIValidator returns a validator of OpenAPI v2 definitions.
+// If builtin is true, the Kubernetes definitions built into kyaml are used,
+// and definitions in files, such as the output of kubectl get --raw /openapi/v2,
+// are added over them.
+func NewOpenAPIValidator(builtin bool, files []string) (*Validator, error) {
+	root := &spec.Schema{}
+	root.Definitions = spec.Definitions{}
+
+	if builtin {
+		// kyamlのグローバルなスキーマはビルド中に変更されうるので、最初にコピーしておく
+		for k, d := range openapi.Schema().Definitions {
+			root.Definitions[k] = d
+		}
+	}
+
+	for _, f := range files {
+		defs, err := readDefinitions(f)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for k, d := range defs {
+			root.Definitions[k] = d
+		}
+	}
+
+	schemas := map[kyaml.TypeMeta]*spec.Schema{}
+	for k := range root.Definitions {
+		d := root.Definitions[k]
+
+		for _, t := range typeMetas(&d) {
+			schemas[t] = &d
+		}
+	}
+
+	return &Validator{
+		name:    "schema",
+		root:    root,
+		schemas: schemas,
+	}, nil
+}
+
+// readDefinitions reads the definitions of an OpenAPI v2 document in JSON or YAML.
+func readDefinitions(path string) (spec.Definitions, error) {
+	b, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema: %w", err)
+	}
+
+	b, err = k8syaml.YAMLToJSON(b)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse schema %s: %w", path, err)
+	}
+
+	var swagger spec.Swagger
+	if err := swagger.UnmarshalJSON(b); err != nil {
+		return nil, fmt.Errorf("failed to parse schema %s: %w", path, err)
+	}
+
+	return swagger.Definitions, nil
+}
+
+// typeMetas returns the types in the group-version-kind extension of the definition.
+func typeMetas(s *spec.Schema) []kyaml.TypeMeta {
+	exts, ok := s.Extensions[gvkExtension].([]interface{})
+	if !ok {
+		return nil
+	}
+
+	var types []kyaml.TypeMeta
+	for _, ext := range exts {
+		m, ok := ext.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		group, _ := m["group"].(string)
+		version, _ := m["version"].(string)
+		kind, _ := m["kind"].(string)
+
+		apiVersion := version
+		if group != "" {
+			apiVersion = group + "/" + version
+		}
+
+		types = append(types, kyaml.TypeMeta{APIVersion: apiVersion, Kind: kind})
+	}
+
+	return types
+}
+
+func (v *Validator) Name() string {
+	return v.name
+}
+
+// Check validates every resource which has a schema.
+func (v *Validator) Check(resMap resmap.ResMap) ([]check.Finding, error) {
+	var findings []check.Finding
+
+	for _, res := range resMap.Resources() {
+		s, ok := v.schemas[kyaml.TypeMeta{APIVersion: res.GetApiVersion(), Kind: res.GetKind()}]
+		if !ok {
+			continue
+		}
+
+		w := &walker{
+			v:        v,
+			resource: check.ResourceID(res),
+		}
+		w.validate(res.YNode(), s, "")
+
+		findings = append(findings, w.findings...)
+	}
+
+	return findings, nil
+}
+
+// resolve follows $ref of s. Resolved references are cached since the same
+// definitions such as ObjectMeta are referred from every resource.
+func (v *Validator) resolve(s *spec.Schema) (*spec.Schema, error) {
+	for s.Ref.String() != "" {
+		ref := s.Ref.String()
+
+		if cached, ok := v.refs.Load(ref); ok {
+			s = cached.(*spec.Schema)
+			continue
+		}
+
+		if v.root == nil {
+			return nil, fmt.Errorf("unresolvable reference %s", ref)
+		}
+
+		resolved, err := openapi.Resolve(&s.Ref, v.root)
+
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", ref, err)
+		}
+
+		v.refs.Store(ref, resolved)
+		s = resolved
+	}
+
+	return s, nil
+}
@@ -0,0 +1,219 @@
+package schema
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/tsuzu/kachtomize/pkg/check"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+const (
+	extIntOrString     = "x-kubernetes-int-or-string"
+	extPreserveUnknown = "x-kubernetes-preserve-unknown-fields"
+	extEmbedded        = "x-kubernetes-embedded-resource"
+
+	// quantityRef is the definition of resource.Quantity, which accepts numbers as well as strings.
+	quantityRef = "io.k8s.apimachinery.pkg.api.resource.Quantity"
+)
+
+// walker validates a resource against its schema and collects findings.
+type walker struct {
+	v        *Validator
+	resource string
+	findings []check.Finding
+}
+
+func (w *walker) report(field, format string, args ...interface{}) {
+	w.findings = append(w.findings, check.Finding{
+		Check:    w.v.name,
+		Severity: check.SeverityError,
+		Resource: w.resource,
+		Field:    field,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+func (w *walker) validate(n *kyaml.Node, s *spec.Schema, path string) {
+	if n.Kind == kyaml.AliasNode {
+		n = n.Alias
+	}
+
+	// nullは省略と同じ扱いになるので検査しない
+	if n.Kind == kyaml.ScalarNode && n.ShortTag() == kyaml.NodeTagNull {
+		return
+	}
+
+	lenient := strings.HasSuffix(s.Ref.String(), quantityRef)
+
+	s, err := w.v.resolve(s)
+
+	if err != nil {
+		w.report(path, "failed to resolve schema: %v", err)
+		return
+	}
+	if s == nil {
+		return
+	}
+
+	for i := range s.AllOf {
+		w.validate(n, &s.AllOf[i], path)
+	}
+
+	if lenient || s.Format == "int-or-string" || boolExtension(s, extIntOrString) {
+		if tag := n.ShortTag(); n.Kind != kyaml.ScalarNode || (tag != kyaml.NodeTagString && tag != kyaml.NodeTagInt && !(lenient && tag == kyaml.NodeTagFloat)) {
+			w.report(path, "expected integer or string, got %s", nodeType(n))
+		}
+
+		return
+	}
+
+	typ := ""
+	if len(s.Type) != 0 {
+		typ = s.Type[0]
+	} else if len(s.Properties) != 0 {
+		typ = "object"
+	}
+
+	switch typ {
+	case "object":
+		w.validateObject(n, s, path)
+	case "array":
+		if n.Kind != kyaml.SequenceNode {
+			w.report(path, "expected array, got %s", nodeType(n))
+			return
+		}
+
+		if s.Items == nil || s.Items.Schema == nil {
+			return
+		}
+
+		for i, c := range n.Content {
+			w.validate(c, s.Items.Schema, elementPath(path, i, c))
+		}
+	case "string":
+		if n.Kind != kyaml.ScalarNode || !isString(n) {
+			w.report(path, "expected string, got %s", nodeType(n))
+		}
+	case "integer":
+		if n.Kind != kyaml.ScalarNode || n.ShortTag() != kyaml.NodeTagInt {
+			w.report(path, "expected integer, got %s", nodeType(n))
+		}
+	case "number":
+		if tag := n.ShortTag(); n.Kind != kyaml.ScalarNode || (tag != kyaml.NodeTagInt && tag != kyaml.NodeTagFloat) {
+			w.report(path, "expected number, got %s", nodeType(n))
+		}
+	case "boolean":
+		if n.Kind != kyaml.ScalarNode || n.ShortTag() != kyaml.NodeTagBool {
+			w.report(path, "expected boolean, got %s", nodeType(n))
+		}
+	}
+}
+
+func (w *walker) validateObject(n *kyaml.Node, s *spec.Schema, path string) {
+	if n.Kind != kyaml.MappingNode {
+		w.report(path, "expected object, got %s", nodeType(n))
+		return
+	}
+
+	// プロパティが定義されていないobjectは任意のフィールドを持てる
+	closed := len(s.Properties) != 0 && !boolExtension(s, extPreserveUnknown) && !boolExtension(s, extEmbedded)
+
+	present := map[string]bool{}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key := n.Content[i].Value
+		value := n.Content[i+1]
+		fieldPath := joinPath(path, key)
+		present[key] = true
+
+		if prop, ok := s.Properties[key]; ok {
+			w.validate(value, &prop, fieldPath)
+			continue
+		}
+
+		if ap := s.AdditionalProperties; ap != nil {
+			if ap.Schema != nil {
+				w.validate(value, ap.Schema, fieldPath)
+				continue
+			}
+			if ap.Allows {
+				continue
+			}
+
+			closed = true
+		}
+
+		if closed {
+			w.report(fieldPath, "unknown field %q", key)
+		}
+	}
+
+	for _, r := range s.Required {
+		if !present[r] {
+			w.report(path, "missing required field %q", r)
+		}
+	}
+}
+
+func boolExtension(s *spec.Schema, key string) bool {
+	v, ok := s.Extensions[key]
+	if !ok {
+		return false
+	}
+
+	b, ok := v.(bool)
+
+	return ok && b
+}
+
+func isString(n *kyaml.Node) bool {
+	switch n.ShortTag() {
+	case kyaml.NodeTagString, "!!timestamp", "!!binary":
+		return true
+	default:
+		return false
+	}
+}
+
+func nodeType(n *kyaml.Node) string {
+	switch n.Kind {
+	case kyaml.MappingNode:
+		return "object"
+	case kyaml.SequenceNode:
+		return "array"
+	}
+
+	switch n.ShortTag() {
+	case kyaml.NodeTagInt:
+		return "integer " + n.Value
+	case kyaml.NodeTagFloat:
+		return "number " + n.Value
+	case kyaml.NodeTagBool:
+		return "boolean " + n.Value
+	default:
+		return "string " + strconv.Quote(n.Value)
+	}
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+
+	return path + "." + key
+}
+
+// elementPath identifies list elements by their names if they have one, like semdiff.
+func elementPath(path string, i int, n *kyaml.Node) string {
+	if n.Kind == kyaml.MappingNode {
+		for j := 0; j+1 < len(n.Content); j += 2 {
+			if n.Content[j].Value == "name" && n.Content[j+1].Kind == kyaml.ScalarNode {
+				return fmt.Sprintf("%s[name=%s]", path, n.Content[j+1].Value)
+			}
+		}
+	}
+
+	return fmt.Sprintf("%s[%d]", path, i)
+}
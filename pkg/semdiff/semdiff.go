@@ -0,0 +1,413 @@
+package semdiff
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+type ChangeType string
+
+const (
+	Added    ChangeType = "added"
+	Removed  ChangeType = "removed"
+	Modified ChangeType = "modified"
+)
+
+// FieldChange is a change of a single field in a resource.
+// Old or New is empty when the field is added or removed.
+type FieldChange struct {
+	Path string
+	Old  string
+	New  string
+}
+
+func (f FieldChange) String() string {
+	return fmt.Sprintf("%s %s→%s", f.Path, orAbsent(f.Old), orAbsent(f.New))
+}
+
+func orAbsent(s string) string {
+	if s == "" {
+		return "<absent>"
+	}
+
+	return s
+}
+
+// Change is a change of a resource identified by its GVK, namespace and name.
+type Change struct {
+	Type     ChangeType
+	Resource string
+	Fields   []FieldChange
+}
+
+// Lines returns human readable lines like "Deployment prod/api: spec.replicas 3→5".
+func (c Change) Lines() []string {
+	switch c.Type {
+	case Added:
+		return []string{"+ " + c.Resource}
+	case Removed:
+		return []string{"- " + c.Resource}
+	}
+
+	lines := make([]string, 0, len(c.Fields))
+	for _, f := range c.Fields {
+		lines = append(lines, "~ "+c.Resource+": "+f.String())
+	}
+
+	return lines
+}
+
+// Parse parses a multi-document YAML artifact.
+func Parse(b []byte) ([]*yaml.RNode, error) {
+	nodes, err := (&kio.ByteReader{
+		Reader:                bytes.NewReader(b),
+		OmitReaderAnnotations: true,
+	}).Read()
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse YAML: %w", err)
+	}
+
+	return nodes, nil
+}
+
+// DiffBytes parses both artifacts and compares them.
+func DiffBytes(oldYAML, newYAML []byte) ([]Change, error) {
+	oldNodes, err := Parse(oldYAML)
+
+	if err != nil {
+		return nil, fmt.Errorf("old artifact: %w", err)
+	}
+
+	newNodes, err := Parse(newYAML)
+
+	if err != nil {
+		return nil, fmt.Errorf("new artifact: %w", err)
+	}
+
+	return Diff(oldNodes, newNodes), nil
+}
+
+type resourceKey struct {
+	apiVersion string
+	kind       string
+	namespace  string
+	name       string
+}
+
+func keyOf(n *yaml.RNode) resourceKey {
+	return resourceKey{
+		apiVersion: n.GetApiVersion(),
+		kind:       n.GetKind(),
+		namespace:  n.GetNamespace(),
+		name:       n.GetName(),
+	}
+}
+
+func (k resourceKey) String() string {
+	if k.namespace == "" {
+		return k.kind + " " + k.name
+	}
+
+	return k.kind + " " + k.namespace + "/" + k.name
+}
+
+func (k resourceKey) less(o resourceKey) bool {
+	if k.kind != o.kind {
+		return k.kind < o.kind
+	}
+	if k.namespace != o.namespace {
+		return k.namespace < o.namespace
+	}
+	if k.name != o.name {
+		return k.name < o.name
+	}
+
+	return k.apiVersion < o.apiVersion
+}
+
+// Diff matches resources by GVK, namespace and name and returns
+// added, removed and modified resources sorted by kind, namespace and name.
+// ConfigMaps and Secrets whose names differ only in the kustomize hash suffix
+// are treated as the same resource.
+func Diff(oldNodes, newNodes []*yaml.RNode) []Change {
+	oldMap := make(map[resourceKey]*yaml.RNode, len(oldNodes))
+	for _, n := range oldNodes {
+		oldMap[keyOf(n)] = n
+	}
+	newMap := make(map[resourceKey]*yaml.RNode, len(newNodes))
+	for _, n := range newNodes {
+		newMap[keyOf(n)] = n
+	}
+
+	type pair struct {
+		key      resourceKey
+		old, new *yaml.RNode
+	}
+	var pairs []pair
+
+	var removed []resourceKey
+	for k, o := range oldMap {
+		if n, ok := newMap[k]; ok {
+			pairs = append(pairs, pair{key: k, old: o, new: n})
+			delete(newMap, k)
+		} else {
+			removed = append(removed, k)
+		}
+	}
+
+	// 片方にしかないリソースのうち、hash suffixだけが違うものは同じリソースとみなす
+	added := make(map[resourceKey]struct{}, len(newMap))
+	for k := range newMap {
+		added[k] = struct{}{}
+	}
+	sort.Slice(removed, func(i, j int) bool { return removed[i].less(removed[j]) })
+
+	var changes []Change
+	for _, k := range removed {
+		if nk, ok := findRenamed(k, added); ok {
+			delete(added, nk)
+			pairs = append(pairs, pair{key: nk, old: oldMap[k], new: newMap[nk]})
+			continue
+		}
+
+		changes = append(changes, Change{Type: Removed, Resource: k.String()})
+	}
+	for k := range added {
+		changes = append(changes, Change{Type: Added, Resource: k.String()})
+	}
+
+	for _, p := range pairs {
+		var fields []FieldChange
+		diffNode("", p.old.YNode(), p.new.YNode(), &fields)
+
+		if len(fields) != 0 {
+			changes = append(changes, Change{Type: Modified, Resource: p.key.String(), Fields: fields})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Resource != changes[j].Resource {
+			return changes[i].Resource < changes[j].Resource
+		}
+
+		return changes[i].Type < changes[j].Type
+	})
+
+	return changes
+}
+
+// hashSuffixPattern matches the suffix added by the kustomize hasher, which encodes
+// hex digits with 0, 1, 3, a and e replaced by g, h, k, m and t.
+var hashSuffixPattern = regexp.MustCompile(`^(.+)-[2456789bcdfghkmt]{10}$`)
+
+// hashedKinds are the kinds whose names kustomize adds the hash suffix to.
+var hashedKinds = map[string]bool{
+	"ConfigMap": true,
+	"Secret":    true,
+}
+
+func trimHashSuffix(name string) (string, bool) {
+	m := hashSuffixPattern.FindStringSubmatch(name)
+	if m == nil {
+		return "", false
+	}
+
+	return m[1], true
+}
+
+func findRenamed(old resourceKey, candidates map[resourceKey]struct{}) (resourceKey, bool) {
+	if !hashedKinds[old.kind] {
+		return resourceKey{}, false
+	}
+
+	base, ok := trimHashSuffix(old.name)
+	if !ok {
+		return resourceKey{}, false
+	}
+
+	for k := range candidates {
+		if k.apiVersion != old.apiVersion || k.kind != old.kind || k.namespace != old.namespace {
+			continue
+		}
+		if b, ok := trimHashSuffix(k.name); ok && b == base {
+			return k, true
+		}
+	}
+
+	return resourceKey{}, false
+}
+
+func diffNode(path string, o, n *yaml.Node, fields *[]FieldChange) {
+	if o.Kind != n.Kind {
+		*fields = append(*fields, FieldChange{Path: path, Old: render(o), New: render(n)})
+		return
+	}
+
+	switch o.Kind {
+	case yaml.MappingNode:
+		diffMapping(path, o, n, fields)
+	case yaml.SequenceNode:
+		diffSequence(path, o, n, fields)
+	default:
+		// tagは生成元によって省略されていることがあるので、両方にある場合だけ比べる
+		if o.Value != n.Value || (o.Tag != "" && n.Tag != "" && o.Tag != n.Tag) {
+			*fields = append(*fields, FieldChange{Path: path, Old: render(o), New: render(n)})
+		}
+	}
+}
+
+func mappingFields(n *yaml.Node) (keys []string, values map[string]*yaml.Node) {
+	values = make(map[string]*yaml.Node, len(n.Content)/2)
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		k := n.Content[i].Value
+		keys = append(keys, k)
+		values[k] = n.Content[i+1]
+	}
+
+	return keys, values
+}
+
+func diffMapping(path string, o, n *yaml.Node, fields *[]FieldChange) {
+	oldKeys, oldValues := mappingFields(o)
+	newKeys, newValues := mappingFields(n)
+
+	keys := oldKeys
+	for _, k := range newKeys {
+		if _, ok := oldValues[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		p := joinPath(path, k)
+		ov, oldOK := oldValues[k]
+		nv, newOK := newValues[k]
+
+		switch {
+		case !oldOK:
+			*fields = append(*fields, FieldChange{Path: p, New: render(nv)})
+		case !newOK:
+			*fields = append(*fields, FieldChange{Path: p, Old: render(ov)})
+		default:
+			diffNode(p, ov, nv, fields)
+		}
+	}
+}
+
+// elementName returns the value of the "name" field which is
+// used as the merge key of most lists in Kubernetes resources.
+func elementName(n *yaml.Node) (string, bool) {
+	if n.Kind != yaml.MappingNode {
+		return "", false
+	}
+
+	_, values := mappingFields(n)
+	v, ok := values["name"]
+	if !ok || v.Kind != yaml.ScalarNode {
+		return "", false
+	}
+
+	return v.Value, true
+}
+
+func namedElements(n *yaml.Node) (names []string, values map[string]*yaml.Node, ok bool) {
+	values = make(map[string]*yaml.Node, len(n.Content))
+	for _, c := range n.Content {
+		name, ok := elementName(c)
+		if !ok {
+			return nil, nil, false
+		}
+		if _, dup := values[name]; dup {
+			return nil, nil, false
+		}
+
+		names = append(names, name)
+		values[name] = c
+	}
+
+	return names, values, true
+}
+
+func diffSequence(path string, o, n *yaml.Node, fields *[]FieldChange) {
+	oldNames, oldValues, oldOK := namedElements(o)
+	newNames, newValues, newOK := namedElements(n)
+
+	if oldOK && newOK && len(o.Content) != 0 && len(n.Content) != 0 {
+		names := oldNames
+		for _, name := range newNames {
+			if _, ok := oldValues[name]; !ok {
+				names = append(names, name)
+			}
+		}
+
+		for _, name := range names {
+			p := path + "[name=" + name + "]"
+			ov, oldOK := oldValues[name]
+			nv, newOK := newValues[name]
+
+			switch {
+			case !oldOK:
+				*fields = append(*fields, FieldChange{Path: p, New: render(nv)})
+			case !newOK:
+				*fields = append(*fields, FieldChange{Path: p, Old: render(ov)})
+			default:
+				diffNode(p, ov, nv, fields)
+			}
+		}
+
+		return
+	}
+
+	for i := 0; i < len(o.Content) || i < len(n.Content); i++ {
+		p := path + "[" + strconv.Itoa(i) + "]"
+
+		switch {
+		case i >= len(o.Content):
+			*fields = append(*fields, FieldChange{Path: p, New: render(n.Content[i])})
+		case i >= len(n.Content):
+			*fields = append(*fields, FieldChange{Path: p, Old: render(o.Content[i])})
+		default:
+			diffNode(p, o.Content[i], n.Content[i], fields)
+		}
+	}
+}
+
+func joinPath(path, key string) string {
+	if strings.ContainsAny(key, ".[]") {
+		key = "[" + strconv.Quote(key) + "]"
+		return path + key
+	}
+	if path == "" {
+		return key
+	}
+
+	return path + "." + key
+}
+
+// render returns a scalar value as it is and
+// a compact JSON representation for other nodes.
+func render(n *yaml.Node) string {
+	if n.Kind == yaml.ScalarNode {
+		if n.Value == "" {
+			return `""`
+		}
+
+		return n.Value
+	}
+
+	b, err := yaml.NewRNode(n).MarshalJSON()
+	if err != nil {
+		return "<" + err.Error() + ">"
+	}
+
+	return string(b)
+}
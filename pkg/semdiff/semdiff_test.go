@@ -0,0 +1,188 @@
+package semdiff
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const deployment = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: api
+  namespace: prod
+spec:
+  replicas: 3
+  template:
+    spec:
+      containers:
+      - name: app
+        image: app:1.0
+      - name: sidecar
+        image: proxy:2.0
+`
+
+func lines(changes []Change) []string {
+	var ls []string
+	for _, c := range changes {
+		ls = append(ls, c.Lines()...)
+	}
+
+	return ls
+}
+
+func TestDiffBytes(t *testing.T) {
+	tests := []struct {
+		name     string
+		old, new string
+		want     []string
+	}{
+		{
+			name: "identical",
+			old:  deployment,
+			new:  deployment,
+		},
+		{
+			name: "scalar",
+			old:  deployment,
+			new:  strings.Replace(deployment, "replicas: 3", "replicas: 5", 1),
+			want: []string{"~ Deployment prod/api: spec.replicas 3→5"},
+		},
+		{
+			name: "added and removed resources",
+			old:  deployment + "---\napiVersion: v1\nkind: Service\nmetadata:\n  name: old\n",
+			new:  deployment + "---\napiVersion: v1\nkind: Service\nmetadata:\n  name: new\n",
+			want: []string{"+ Service new", "- Service old"},
+		},
+		{
+			name: "same name in another namespace",
+			old:  deployment,
+			new:  strings.Replace(deployment, "namespace: prod", "namespace: dev", 1),
+			want: []string{"+ Deployment dev/api", "- Deployment prod/api"},
+		},
+		{
+			name: "hash suffix rename",
+			old:  "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: cfg-7f4hg8m9kc\ndata:\n  key: a\n",
+			new:  "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: cfg-29t7bd8cmg\ndata:\n  key: b\n",
+			want: []string{
+				"~ ConfigMap cfg-29t7bd8cmg: data.key a→b",
+				"~ ConfigMap cfg-29t7bd8cmg: metadata.name cfg-7f4hg8m9kc→cfg-29t7bd8cmg",
+			},
+		},
+		{
+			name: "hash suffix rename to another kind",
+			old:  "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: cfg-7f4hg8m9kc\n",
+			new:  "apiVersion: v1\nkind: Secret\nmetadata:\n  name: cfg-29t7bd8cmg\n",
+			want: []string{"- ConfigMap cfg-7f4hg8m9kc", "+ Secret cfg-29t7bd8cmg"},
+		},
+		{
+			name: "rename of another kind with a suffix like a hash",
+			old:  "apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: api-7f4hg8m9kc\n",
+			new:  "apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: api-29t7bd8cmg\n",
+			want: []string{"+ Deployment api-29t7bd8cmg", "- Deployment api-7f4hg8m9kc"},
+		},
+		{
+			name: "rename of another kind with a 10 character suffix",
+			old:  "apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: api-deployment\n",
+			new:  "apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: api-controller\n",
+			want: []string{"+ Deployment api-controller", "- Deployment api-deployment"},
+		},
+		{
+			name: "suffix outside of the hash alphabet",
+			old:  "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: cfg-deployment\n",
+			new:  "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: cfg-controller\n",
+			want: []string{"+ ConfigMap cfg-controller", "- ConfigMap cfg-deployment"},
+		},
+		{
+			name: "name without hash suffix",
+			old:  "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: cfg-a\n",
+			new:  "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: cfg-b\n",
+			want: []string{"- ConfigMap cfg-a", "+ ConfigMap cfg-b"},
+		},
+		{
+			name: "named list reordered",
+			old:  deployment,
+			new: strings.Replace(deployment,
+				"      - name: app\n        image: app:1.0\n      - name: sidecar\n        image: proxy:2.0\n",
+				"      - name: sidecar\n        image: proxy:2.0\n      - name: app\n        image: app:1.0\n", 1),
+		},
+		{
+			name: "named list element changed",
+			old:  deployment,
+			new:  strings.Replace(deployment, "image: app:1.0", "image: app:1.1", 1),
+			want: []string{"~ Deployment prod/api: spec.template.spec.containers[name=app].image app:1.0→app:1.1"},
+		},
+		{
+			name: "named list element added",
+			old:  deployment,
+			new:  deployment + "      - name: debug\n        image: busybox\n",
+			want: []string{`~ Deployment prod/api: spec.template.spec.containers[name=debug] <absent>→{"image":"busybox","name":"debug"}`},
+		},
+		{
+			name: "list without names",
+			old:  "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: c\n  finalizers:\n  - a\n  - b\n",
+			new:  "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: c\n  finalizers:\n  - b\n",
+			want: []string{
+				"~ ConfigMap c: metadata.finalizers[0] a→b",
+				"~ ConfigMap c: metadata.finalizers[1] b→<absent>",
+			},
+		},
+		{
+			name: "keys with dots",
+			old:  "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: c\n  annotations:\n    example.com/a: x\n",
+			new:  "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: c\n  annotations:\n    example.com/a: y\n",
+			want: []string{`~ ConfigMap c: metadata.annotations["example.com/a"] x→y`},
+		},
+		{
+			name: "type change",
+			old:  "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: c\ndata:\n  a: x\n",
+			new:  "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: c\ndata:\n- a\n",
+			want: []string{`~ ConfigMap c: data {"a":"x"}→["a"]`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changes, err := DiffBytes([]byte(tt.old), []byte(tt.new))
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := lines(changes); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffBytesChangeTypes(t *testing.T) {
+	old := deployment + "---\napiVersion: v1\nkind: Service\nmetadata:\n  name: svc\n"
+	new := strings.Replace(deployment, "replicas: 3", "replicas: 5", 1) + "---\napiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: cfg\n"
+
+	changes, err := DiffBytes([]byte(old), []byte(new))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]ChangeType{}
+	for _, c := range changes {
+		got[c.Resource] = c.Type
+	}
+
+	want := map[string]ChangeType{
+		"ConfigMap cfg":       Added,
+		"Deployment prod/api": Modified,
+		"Service svc":         Removed,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestDiffBytesInvalidYAML(t *testing.T) {
+	if _, err := DiffBytes([]byte(deployment), []byte("a: [")); err == nil {
+		t.Error("expected an error for invalid YAML")
+	}
+}
@@ -0,0 +1,129 @@
+package shard
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ModeHash     = "hash"
+	ModeBalanced = "balanced"
+)
+
+// Shard is the Index-th (1-origin) of Count shards.
+type Shard struct {
+	Index int
+	Count int
+}
+
+// Parse parses "i/n" such as "2/4".
+func Parse(s string) (Shard, error) {
+	i, n, ok := strings.Cut(s, "/")
+
+	if !ok {
+		return Shard{}, fmt.Errorf("invalid shard %q: must be i/n", s)
+	}
+
+	index, err := strconv.Atoi(i)
+
+	if err != nil {
+		return Shard{}, fmt.Errorf("invalid shard index %q: %w", i, err)
+	}
+
+	count, err := strconv.Atoi(n)
+
+	if err != nil {
+		return Shard{}, fmt.Errorf("invalid shard count %q: %w", n, err)
+	}
+
+	if count < 1 || index < 1 || index > count {
+		return Shard{}, fmt.Errorf("invalid shard %q: must be 1 <= i <= n", s)
+	}
+
+	return Shard{Index: index, Count: count}, nil
+}
+
+// SelectHash returns the targets whose stable hash belongs to the shard.
+// The result keeps the order of targets.
+func (s Shard) SelectHash(targets []string) []string {
+	var selected []string
+	for _, t := range targets {
+		h := fnv.New32a()
+		h.Write([]byte(t))
+
+		if int(h.Sum32()%uint32(s.Count)) == s.Index-1 {
+			selected = append(selected, t)
+		}
+	}
+
+	return selected
+}
+
+// SelectBalanced distributes targets over shards so that the total estimated
+// durations are balanced, and returns the targets of the shard.
+// Targets without an estimate are assumed to take the mean of the known ones.
+// Every shard computes the same assignment as long as targets and estimates are the same.
+func (s Shard) SelectBalanced(targets []string, estimate func(target string) (time.Duration, bool)) []string {
+	durations := make(map[string]time.Duration, len(targets))
+
+	var known []string
+	var sum time.Duration
+	for _, t := range targets {
+		if d, ok := estimate(t); ok {
+			durations[t] = d
+			known = append(known, t)
+			sum += d
+		}
+	}
+
+	fallback := time.Second
+	if len(known) != 0 {
+		fallback = sum / time.Duration(len(known))
+	}
+
+	sorted := append([]string(nil), targets...)
+	for _, t := range sorted {
+		if _, ok := durations[t]; !ok {
+			durations[t] = fallback
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		di, dj := durations[sorted[i]], durations[sorted[j]]
+
+		if di != dj {
+			return di > dj
+		}
+
+		return sorted[i] < sorted[j]
+	})
+
+	// 長いものから順に、合計時間が一番小さいshardに割り当てる
+	loads := make([]time.Duration, s.Count)
+	assigned := make(map[string]bool, len(targets))
+	for _, t := range sorted {
+		min := 0
+		for i := range loads {
+			if loads[i] < loads[min] {
+				min = i
+			}
+		}
+
+		loads[min] += durations[t]
+		if min == s.Index-1 {
+			assigned[t] = true
+		}
+	}
+
+	var selected []string
+	for _, t := range targets {
+		if assigned[t] {
+			selected = append(selected, t)
+		}
+	}
+
+	return selected
+}
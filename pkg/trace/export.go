@@ -0,0 +1,204 @@
+package trace
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+)
+
+const (
+	FormatChrome = "chrome"
+	FormatOTLP   = "otlp"
+
+	serviceName = "kachtomize"
+)
+
+// ValidateFormat returns an error if format is not a known trace format.
+func ValidateFormat(format string) error {
+	switch format {
+	case FormatChrome, FormatOTLP:
+		return nil
+	default:
+		return fmt.Errorf("unknown trace format: %s", format)
+	}
+}
+
+// WriteFile writes the recorded spans to path in the format.
+func (t *Tracer) WriteFile(path, format string) error {
+	if err := ValidateFormat(format); err != nil {
+		return err
+	}
+
+	f, err := os.Create(path)
+
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", path, err)
+	}
+	defer f.Close()
+
+	switch format {
+	case FormatChrome:
+		err = t.WriteChrome(f)
+	case FormatOTLP:
+		err = t.WriteOTLP(f)
+	default:
+		return fmt.Errorf("unknown trace format: %s", format)
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to write trace to %s: %w", path, err)
+	}
+
+	return f.Close()
+}
+
+type chromeEvent struct {
+	Name  string            `json:"name"`
+	Cat   string            `json:"cat,omitempty"`
+	Phase string            `json:"ph"`
+	Ts    int64             `json:"ts"`
+	Dur   int64             `json:"dur,omitempty"`
+	Pid   int               `json:"pid"`
+	Tid   int               `json:"tid"`
+	Args  map[string]string `json:"args,omitempty"`
+}
+
+// WriteChrome writes the spans in the Chrome trace event format,
+// which can be loaded by chrome://tracing or Perfetto.
+func (t *Tracer) WriteChrome(w io.Writer) error {
+	spans := t.sortedSpans()
+
+	events := make([]chromeEvent, 0, len(spans))
+	tids := map[int]struct{}{}
+	for _, s := range spans {
+		tids[s.Tid] = struct{}{}
+
+		events = append(events, chromeEvent{
+			Name:  s.Name,
+			Cat:   serviceName,
+			Phase: "X",
+			Ts:    s.Start.UnixMicro(),
+			Dur:   s.End.Sub(s.Start).Microseconds(),
+			Pid:   1,
+			Tid:   s.Tid,
+			Args:  s.Attrs,
+		})
+	}
+
+	sortedTids := make([]int, 0, len(tids))
+	for tid := range tids {
+		sortedTids = append(sortedTids, tid)
+	}
+	sort.Ints(sortedTids)
+
+	for _, tid := range sortedTids {
+		events = append(events, chromeEvent{
+			Name:  "thread_name",
+			Phase: "M",
+			Pid:   1,
+			Tid:   tid,
+			Args:  map[string]string{"name": threadName(tid)},
+		})
+	}
+
+	return json.NewEncoder(w).Encode(map[string]interface{}{
+		"traceEvents":     events,
+		"displayTimeUnit": "ms",
+	})
+}
+
+type otlpAnyValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+}
+
+type otlpScopeSpans struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpResourceSpans struct {
+	Resource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	} `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+func spanID(id uint64) string {
+	return fmt.Sprintf("%016x", id)
+}
+
+func otlpAttributes(attrs map[string]string) []otlpKeyValue {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	kvs := make([]otlpKeyValue, 0, len(keys))
+	for _, k := range keys {
+		kvs = append(kvs, otlpKeyValue{Key: k, Value: otlpAnyValue{StringValue: attrs[k]}})
+	}
+
+	return kvs
+}
+
+// WriteOTLP writes the spans in the OTLP/JSON format.
+func (t *Tracer) WriteOTLP(w io.Writer) error {
+	spans := t.sortedSpans()
+
+	scope := otlpScopeSpans{
+		Spans: make([]otlpSpan, 0, len(spans)),
+	}
+	scope.Scope.Name = serviceName
+
+	for _, s := range spans {
+		attrs := map[string]string{"thread.name": threadName(s.Tid)}
+		for k, v := range s.Attrs {
+			attrs[k] = v
+		}
+
+		span := otlpSpan{
+			TraceID:           t.traceID,
+			SpanID:            spanID(s.ID),
+			Name:              s.Name,
+			Kind:              1, // SPAN_KIND_INTERNAL
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+			Attributes:        otlpAttributes(attrs),
+		}
+		if s.ParentID != 0 {
+			span.ParentSpanID = spanID(s.ParentID)
+		}
+
+		scope.Spans = append(scope.Spans, span)
+	}
+
+	var rs otlpResourceSpans
+	rs.Resource.Attributes = otlpAttributes(map[string]string{"service.name": serviceName})
+	rs.ScopeSpans = []otlpScopeSpans{scope}
+
+	return json.NewEncoder(w).Encode(map[string]interface{}{
+		"resourceSpans": []otlpResourceSpans{rs},
+	})
+}
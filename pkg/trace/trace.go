@@ -0,0 +1,128 @@
+package trace
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Tracer records timing spans of the load and build phases.
+// All methods are safe to call on a nil *Tracer, which records nothing.
+type Tracer struct {
+	lock    sync.Mutex
+	traceID string
+	nextID  uint64
+	spans   []*Span
+}
+
+// Span is a timed operation. Tid is the thread the span is displayed on,
+// which is the worker ID or 0 for the main and callback goroutines.
+type Span struct {
+	tracer *Tracer
+
+	ID       uint64
+	ParentID uint64
+	Name     string
+	Tid      int
+	Start    time.Time
+	End      time.Time
+	Attrs    map[string]string
+}
+
+func New() *Tracer {
+	b := make([]byte, 16)
+	rand.Read(b)
+
+	return &Tracer{
+		traceID: hex.EncodeToString(b),
+	}
+}
+
+// Start starts a root span.
+// attrs are pairs of key and value.
+func (t *Tracer) Start(name string, tid int, attrs ...string) *Span {
+	if t == nil {
+		return nil
+	}
+
+	return t.start(0, name, tid, attrs)
+}
+
+func (t *Tracer) start(parent uint64, name string, tid int, attrs []string) *Span {
+	s := &Span{
+		tracer:   t,
+		ParentID: parent,
+		Name:     name,
+		Tid:      tid,
+		Start:    time.Now(),
+		Attrs:    make(map[string]string, len(attrs)/2),
+	}
+	for i := 0; i+1 < len(attrs); i += 2 {
+		s.Attrs[attrs[i]] = attrs[i+1]
+	}
+
+	t.lock.Lock()
+	t.nextID++
+	s.ID = t.nextID
+	t.lock.Unlock()
+
+	return s
+}
+
+// Child starts a span nested in s on the same thread.
+func (s *Span) Child(name string, attrs ...string) *Span {
+	if s == nil {
+		return nil
+	}
+
+	return s.tracer.start(s.ID, name, s.Tid, attrs)
+}
+
+// SetAttr sets an attribute of the span.
+func (s *Span) SetAttr(key, value string) {
+	if s == nil {
+		return
+	}
+
+	s.Attrs[key] = value
+}
+
+// Finish ends the span and records it.
+func (s *Span) Finish() {
+	if s == nil {
+		return
+	}
+
+	s.End = time.Now()
+
+	s.tracer.lock.Lock()
+	s.tracer.spans = append(s.tracer.spans, s)
+	s.tracer.lock.Unlock()
+}
+
+func (t *Tracer) sortedSpans() []*Span {
+	t.lock.Lock()
+	spans := append([]*Span(nil), t.spans...)
+	t.lock.Unlock()
+
+	sort.Slice(spans, func(i, j int) bool {
+		if !spans[i].Start.Equal(spans[j].Start) {
+			return spans[i].Start.Before(spans[j].Start)
+		}
+
+		return spans[i].ID < spans[j].ID
+	})
+
+	return spans
+}
+
+func threadName(tid int) string {
+	if tid == 0 {
+		return "main"
+	}
+
+	return fmt.Sprintf("worker %d", tid)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"runtime/pprof"
+)
+
+// startProfiling starts CPU profiling if cpuFile is set.
+// The returned function stops it and writes a heap profile if memFile is set.
+func startProfiling(cpuFile, memFile string) (func(), error) {
+	var cpu *os.File
+
+	if cpuFile != "" {
+		f, err := os.Create(cpuFile)
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to create CPU profile: %w", err)
+		}
+
+		if err := pprof.StartCPUProfile(f); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to start CPU profile: %w", err)
+		}
+
+		cpu = f
+	}
+
+	return func() {
+		if cpu != nil {
+			pprof.StopCPUProfile()
+			cpu.Close()
+		}
+
+		if memFile == "" {
+			return
+		}
+
+		f, err := os.Create(memFile)
+
+		if err != nil {
+			log.Printf("failed to create heap profile: %v", err)
+			return
+		}
+		defer f.Close()
+
+		runtime.GC()
+		if err := pprof.WriteHeapProfile(f); err != nil {
+			log.Printf("failed to write heap profile: %v", err)
+		}
+	}, nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/tsuzu/kachtomize/pkg/checksum"
+)
+
+func runVerify(args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	keyFile := fs.String("key", "", "Verify the signature of the manifest with the ed25519 public key in PKIX PEM (required if the manifest is signed)")
+	sigFile := fs.String("sig", "", "Detached signature of the manifest (default: <manifest>.sig)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s verify [flags] SHA256SUMS\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	if err := verify(fs.Arg(0), *keyFile, *sigFile); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// verify checks the signature of the manifest if keyFile is given,
+// and then every file listed in it. It fails if the manifest is signed
+// but keyFile is not given, so that a forgotten -key does not pass silently.
+func verify(sumsFile, keyFile, sigFile string) error {
+	content, err := os.ReadFile(sumsFile)
+
+	if err != nil {
+		return fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	if sigFile == "" {
+		sigFile = sumsFile + checksum.SignatureSuffix
+	}
+
+	if keyFile == "" {
+		if _, err := os.Stat(sigFile); err == nil {
+			return fmt.Errorf("%s is signed by %s, but -key is not given", sumsFile, sigFile)
+		}
+	}
+
+	if keyFile != "" {
+		key, err := checksum.LoadPublicKey(keyFile)
+
+		if err != nil {
+			return err
+		}
+
+		sig, err := os.ReadFile(sigFile)
+
+		if err != nil {
+			return fmt.Errorf("failed to read signature: %w", err)
+		}
+
+		if err := checksum.VerifySignature(key, content, sig); err != nil {
+			return fmt.Errorf("%s: %w", sumsFile, err)
+		}
+	}
+
+	sums, err := checksum.Parse(content)
+
+	if err != nil {
+		return fmt.Errorf("%s: %w", sumsFile, err)
+	}
+
+	mismatches := sums.Verify(filepath.Dir(sumsFile))
+
+	for _, m := range mismatches {
+		fmt.Fprintln(os.Stderr, m)
+	}
+
+	if len(mismatches) != 0 {
+		return fmt.Errorf("%d of %d files failed verification", len(mismatches), sums.Len())
+	}
+
+	fmt.Printf("%d files verified\n", sums.Len())
+
+	if keyFile == "" {
+		fmt.Println("signature not checked: -key is not given")
+	}
+
+	return nil
+}